@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const activityHistorySize = 50
+
+type (
+	ActivityEntry struct {
+		Time      time.Time   `json:"time"`
+		GroupId   string      `json:"group_id,omitempty"`
+		Sender    string      `json:"sender,omitempty"`
+		Text      string      `json:"text,omitempty"`
+		Action    RouteAction `json:"action,omitempty"`
+		Decision  string      `json:"decision,omitempty"`
+		Receivers []string    `json:"receivers,omitempty"`
+		Error     string      `json:"error,omitempty"`
+	}
+
+	ActivityStatus struct {
+		IsConnected  bool            `json:"is_connected"`
+		ConnectedAt  *time.Time      `json:"connected_at,omitempty"`
+		LastReceived *time.Time      `json:"last_received,omitempty"`
+		Recent       []ActivityEntry `json:"recent"`
+		Errors       []ActivityEntry `json:"errors"`
+	}
+
+	// Activity keeps the connection state and the latest routed messages and errors, for the status endpoints
+	Activity struct {
+		mu           sync.Mutex
+		isConnected  bool
+		connectedAt  time.Time
+		lastReceived time.Time
+		recent       []ActivityEntry
+		errors       []ActivityEntry
+	}
+)
+
+func (a *Activity) SetConnected(isConnected bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.isConnected = isConnected
+	if isConnected {
+		a.connectedAt = time.Now()
+	}
+}
+
+func (a *Activity) IsConnected() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.isConnected
+}
+
+// Record keeps the messages matched by a forwarding record; errors are kept separately. The sender is shown
+// as the rule displays the author, see Processor.AuthorName.
+func (a *Activity) Record(env *SignalEnvelope, sender string, decision RoutingDecision, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastReceived = time.Now()
+
+	if decision.Rule == nil && err == nil {
+		return //not related to forwarding
+	}
+
+	entry := ActivityEntry{
+		Time:     time.Now(),
+		GroupId:  env.DataMessage.GroupInfo.GroupId,
+		Sender:   sender,
+		Text:     truncateText(env.DataMessage.Message, 200),
+		Action:   decision.Action,
+		Decision: decision.String(),
+	}
+	if decision.Rule != nil {
+		entry.Receivers = decision.Receivers
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+		a.errors = appendLimited(a.errors, entry)
+		return
+	}
+
+	a.recent = appendLimited(a.recent, entry)
+}
+
+// Error keeps an error not related to a single inbound message, like a failed album or digest send
+func (a *Activity) Error(groupId string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.errors = appendLimited(a.errors, ActivityEntry{Time: time.Now(), GroupId: groupId, Error: err.Error()})
+}
+
+// Status returns a snapshot with the newest entries first
+func (a *Activity) Status() ActivityStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := ActivityStatus{
+		IsConnected: a.isConnected,
+		Recent:      reversed(a.recent),
+		Errors:      reversed(a.errors),
+	}
+	if !a.connectedAt.IsZero() {
+		at := a.connectedAt
+		s.ConnectedAt = &at
+	}
+	if !a.lastReceived.IsZero() {
+		at := a.lastReceived
+		s.LastReceived = &at
+	}
+
+	return s
+}
+
+func appendLimited(entries []ActivityEntry, entry ActivityEntry) []ActivityEntry {
+	entries = append(entries, entry)
+	if len(entries) > activityHistorySize {
+		entries = entries[len(entries)-activityHistorySize:]
+	}
+
+	return entries
+}
+
+func reversed(entries []ActivityEntry) []ActivityEntry {
+	r := make([]ActivityEntry, len(entries))
+	for i, e := range entries {
+		r[len(entries)-1-i] = e
+	}
+
+	return r
+}
+
+func truncateText(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+
+	return string(runes[:limit]) + "…"
+}
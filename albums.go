@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAlbumMaxAttachments is the Signal limit of attachments in one message
+const DefaultAlbumMaxAttachments = 32
+
+type (
+	albumItem struct {
+		env      SignalEnvelope
+		decision RoutingDecision
+	}
+	album struct {
+		rule        *ConfigGroup
+		receivers   []string
+		items       []albumItem
+		attachments int
+		timer       *time.Timer
+	}
+
+	// AlbumBatcher collects attachments sent by one author in quick succession, so they are forwarded as one message
+	AlbumBatcher struct {
+		mu      sync.Mutex
+		albums  map[string]*album
+		flushFn func(a *album)
+	}
+)
+
+func NewAlbumBatcher(flushFn func(a *album)) *AlbumBatcher {
+	return &AlbumBatcher{
+		albums:  make(map[string]*album),
+		flushFn: flushFn,
+	}
+}
+
+func albumKey(rule *ConfigGroup, env *SignalEnvelope) string {
+	sender := env.SourceUuid
+	if len(sender) == 0 {
+		sender = env.Source
+	}
+
+	return rule.SourceGroupId() + "/" + sender
+}
+
+// Add puts the forward decision into the author's album for the decision's receivers (they differ
+// with routes), sending the album first if it would overflow
+func (b *AlbumBatcher) Add(decision RoutingDecision, env *SignalEnvelope) {
+	rule := decision.Rule
+	key := albumKey(rule, env) + "|" + strings.Join(decision.Receivers, ",")
+	window := time.Duration(rule.AlbumWindowMs) * time.Millisecond
+
+	b.mu.Lock()
+	a := b.albums[key]
+	var full *album
+	if a != nil && a.attachments+len(decision.Attachments) > rule.AlbumMaxAttachments {
+		full = b.take(key)
+		a = nil
+	}
+	if a == nil {
+		a = &album{rule: rule, receivers: decision.Receivers}
+		created := a
+		a.timer = time.AfterFunc(window, func() { b.flushExpired(key, created) })
+		b.albums[key] = a
+	} else {
+		a.timer.Reset(window)
+	}
+	a.items = append(a.items, albumItem{env: *env, decision: decision})
+	a.attachments += len(decision.Attachments)
+	b.mu.Unlock()
+
+	if full != nil {
+		b.flushFn(full)
+	}
+}
+
+// Flush sends the albums of the author given by the albumKey, if any
+func (b *AlbumBatcher) Flush(author string) {
+	b.mu.Lock()
+	var pending []*album
+	for key := range b.albums {
+		if strings.HasPrefix(key, author+"|") {
+			pending = append(pending, b.take(key))
+		}
+	}
+	b.mu.Unlock()
+
+	for _, a := range pending {
+		b.flushFn(a)
+	}
+}
+
+// flushExpired sends the album on its timer, unless it has been sent and replaced already
+func (b *AlbumBatcher) flushExpired(key string, expired *album) {
+	b.mu.Lock()
+	if b.albums[key] != expired {
+		b.mu.Unlock()
+		return
+	}
+	a := b.take(key)
+	b.mu.Unlock()
+
+	b.flushFn(a)
+}
+
+func (b *AlbumBatcher) FlushAll() {
+	b.mu.Lock()
+	pending := make([]*album, 0, len(b.albums))
+	for key := range b.albums {
+		pending = append(pending, b.take(key))
+	}
+	b.mu.Unlock()
+
+	for _, a := range pending {
+		b.flushFn(a)
+	}
+}
+
+// take removes the album from the batcher, must be called with the lock held
+func (b *AlbumBatcher) take(key string) *album {
+	a, ok := b.albums[key]
+	if !ok {
+		return nil
+	}
+	a.timer.Stop()
+	delete(b.albums, key)
+
+	return a
+}
+
+// dispatchAlbum sends the collected attachments as one message (or several, respecting album_max_attachments)
+func (p *Processor) dispatchAlbum(a *album) {
+	rule, items := a.rule, a.items
+	if len(items) == 0 {
+		return
+	}
+
+	var (
+		attachments []SignalAttachments
+		texts       []string
+		envs        = make([]*SignalEnvelope, len(items))
+	)
+	for i := range items {
+		envs[i] = &items[i].env
+		attachments = append(attachments, items[i].decision.Attachments...)
+
+		text := items[i].decision.Text
+		if len(strings.TrimSpace(text)) == 0 {
+			continue
+		}
+		isDup := false
+		for _, t := range texts {
+			if t == text {
+				isDup = true
+				break
+			}
+		}
+		if !isDup {
+			texts = append(texts, text)
+		}
+	}
+
+	Rlog.Debugf("sending album of %d attachments from %d messages of group %s", len(attachments), len(items), rule.SourceGroupId())
+
+	text := strings.Join(texts, "\n")
+	if len(texts) == 0 {
+		text = items[0].decision.Text
+	}
+
+	if err := p.dispatchChunked(rule, a.receivers, text, attachments, envs); err != nil {
+		Rlog.Errorf("album of group %s send error: %v", rule.SourceGroupId(), err)
+	}
+}
+
+// dispatchChunked sends the text with the attachments split into messages of at most album_max_attachments
+// (or the Signal limit), acknowledging the envelopes once, after the last message
+func (p *Processor) dispatchChunked(rule *ConfigGroup, receivers []string, text string, attachments []SignalAttachments, envs []*SignalEnvelope) error {
+	size := rule.AlbumMaxAttachments
+	if size <= 0 {
+		size = DefaultAlbumMaxAttachments
+	}
+
+	start := 0
+	for {
+		end := min(start+size, len(attachments))
+
+		chunkEnvs := envs
+		if end < len(attachments) {
+			chunkEnvs = nil
+		}
+		decision := Forward(rule, text, attachments[start:end])
+		decision.Receivers = receivers
+		if err := p.Dispatch(decision, chunkEnvs...); err != nil {
+			return err
+		}
+		if end == len(attachments) {
+			return nil
+		}
+
+		start, text = end, ""
+	}
+}
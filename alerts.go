@@ -0,0 +1,341 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+
+	alertStateTTL = 7 * 24 * time.Hour //sent alerts not updated for that long are forgotten
+
+	defaultAlertTemplate = `{{if eq .Status "firing"}}🔥 FIRING{{else}}✅ RESOLVED{{end}}: {{.Labels.alertname}}
+{{- with .Annotations.summary}}
+{{.}}{{end}}
+{{- with .Annotations.description}}
+{{.}}{{end}}
+{{- with .Labels.severity}}
+Severity: {{.}}{{end}}
+Started: {{.StartsAt.UTC.Format "2006-01-02 15:04 MST"}}
+{{- if eq .Status "resolved"}}
+Resolved: {{.EndsAt.UTC.Format "2006-01-02 15:04 MST"}}{{end}}`
+)
+
+var labelMatcherRe = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+type (
+	// AlertmanagerPayload is the Alertmanager webhook (version 4) body, also sent by Grafana alerting
+	AlertmanagerPayload struct {
+		Version           string            `json:"version"`
+		GroupKey          string            `json:"groupKey"`
+		Status            string            `json:"status"`
+		Receiver          string            `json:"receiver"`
+		GroupLabels       map[string]string `json:"groupLabels"`
+		CommonLabels      map[string]string `json:"commonLabels"`
+		CommonAnnotations map[string]string `json:"commonAnnotations"`
+		ExternalURL       string            `json:"externalURL"`
+		Alerts            []Alert           `json:"alerts"`
+	}
+
+	Alert struct {
+		Status       string            `json:"status"`
+		Labels       map[string]string `json:"labels"`
+		Annotations  map[string]string `json:"annotations"`
+		StartsAt     time.Time         `json:"startsAt"`
+		EndsAt       time.Time         `json:"endsAt"`
+		GeneratorURL string            `json:"generatorURL"`
+		Fingerprint  string            `json:"fingerprint"`
+	}
+
+	// LabelMatcher is an Alertmanager-style matcher: name=value, name!=value, name=~regex or name!~regex
+	LabelMatcher struct {
+		Name  string
+		Op    string
+		Value string
+		re    *regexp.Regexp
+	}
+
+	// sentAlert is the messages sent for the alert fingerprint, edited on the alert updates
+	sentAlert struct {
+		refs      map[string]MessageRef //receiver -> sent message
+		text      string
+		isPartial bool //some receivers failed, the next notification sends to them again
+		updatedAt time.Time
+	}
+
+	// AlertTracker remembers the messages sent for the firing alerts
+	AlertTracker struct {
+		mu       sync.Mutex
+		sent     map[string]sentAlert
+		handling sync.Mutex //notifications are handled one by one, so the updates of an alert don't race
+	}
+
+	AlertsResult struct {
+		Sent      int `json:"sent"`
+		Edited    int `json:"edited"`
+		Unchanged int `json:"unchanged"` //the message of the alert is up to date
+		Dropped   int `json:"dropped"`   //no receivers for the alert
+	}
+)
+
+func ParseLabelMatcher(s string) (LabelMatcher, error) {
+	m := labelMatcherRe.FindStringSubmatch(s)
+	if m == nil {
+		return LabelMatcher{}, fmt.Errorf("invalid label matcher: %s", s)
+	}
+
+	matcher := LabelMatcher{Name: m[1], Op: m[2], Value: m[3]}
+	if strings.HasPrefix(matcher.Value, `"`) {
+		value, err := strconv.Unquote(matcher.Value)
+		if err != nil {
+			return LabelMatcher{}, fmt.Errorf("invalid label matcher value: %s", s)
+		}
+		matcher.Value = value
+	}
+
+	if matcher.Op == "=~" || matcher.Op == "!~" {
+		re, err := regexp.Compile("^(?:" + matcher.Value + ")$")
+		if err != nil {
+			return LabelMatcher{}, fmt.Errorf("label matcher regex: %w", err)
+		}
+		matcher.re = re
+	}
+
+	return matcher, nil
+}
+
+// Match checks the label, a missing label is an empty value
+func (m *LabelMatcher) Match(labels map[string]string) bool {
+	value := labels[m.Name]
+	switch m.Op {
+	case "=":
+		return value == m.Value
+	case "!=":
+		return value != m.Value
+	case "=~":
+		return m.re.MatchString(value)
+	case "!~":
+		return !m.re.MatchString(value)
+	}
+
+	return false
+}
+
+// Match checks the alert labels against all the route matchers; the matchers not parsed by the config
+// validation are parsed here, and the route with an invalid one matches nothing
+func (ar *AlertRouteConfig) Match(labels map[string]string) bool {
+	matchers := ar.matchers
+	if len(matchers) != len(ar.Matchers) {
+		matchers = make([]LabelMatcher, len(ar.Matchers))
+		for i, s := range ar.Matchers {
+			var err error
+			if matchers[i], err = ParseLabelMatcher(s); err != nil {
+				Rlog.Errorf("alerts route error: %v", err)
+				return false
+			}
+		}
+	}
+
+	for i := range matchers {
+		if !matchers[i].Match(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// AlertReceivers returns the receivers of the matching routes, or the default receivers when no route matches
+func (ac *AlertsConfig) AlertReceivers(labels map[string]string) []string {
+	var receivers []string
+	matched := false
+	for i := range ac.Routes {
+		if !ac.Routes[i].Match(labels) {
+			continue
+		}
+		matched = true
+		receivers = appendUnique(receivers, ac.Routes[i].Receivers()...)
+		if ac.Routes[i].StopOnMatch {
+			break
+		}
+	}
+
+	if !matched {
+		return ac.Receivers()
+	}
+
+	return receivers
+}
+
+func (ac *AlertsConfig) compileTemplate() (*template.Template, error) {
+	text := ac.Template
+	if len(text) == 0 {
+		text = defaultAlertTemplate
+	}
+
+	return template.New("alert").Option("missingkey=zero").Parse(text)
+}
+
+// Key is the alert fingerprint, or the hash of its labels when the sender has no fingerprints
+func (a *Alert) Key() string {
+	if len(a.Fingerprint) > 0 {
+		return a.Fingerprint
+	}
+
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s=%s\n", name, a.Labels[name])
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func NewAlertTracker() *AlertTracker {
+	return &AlertTracker{sent: make(map[string]sentAlert)}
+}
+
+func (t *AlertTracker) Get(key string) (sentAlert, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sent, ok := t.sent[key]
+
+	return sent, ok
+}
+
+func (t *AlertTracker) Set(key string, sent sentAlert) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for k, s := range t.sent {
+		if sent.updatedAt.Sub(s.updatedAt) > alertStateTTL {
+			delete(t.sent, k)
+		}
+	}
+	t.sent[key] = sent
+}
+
+func (t *AlertTracker) Delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.sent, key)
+}
+
+// HandleAlerts posts every alert to the receivers of its routes. The updates of an alert already sent edit its messages,
+// and the resolved alert edits them for the last time; when the edit fails or the receiver's network has no edits,
+// a new message is sent.
+func (p *Processor) HandleAlerts(payload *AlertmanagerPayload) (AlertsResult, error) {
+	var result AlertsResult
+
+	conf := p.Config()
+	if conf.Alerts == nil || !conf.Alerts.IsEnabled {
+		return result, fmt.Errorf("alerts are disabled")
+	}
+
+	tpl, err := conf.Alerts.compileTemplate()
+	if err != nil {
+		return result, err
+	}
+
+	p.alerts.handling.Lock()
+	defer p.alerts.handling.Unlock()
+
+	var errs []error
+	for i := range payload.Alerts {
+		alert := &payload.Alerts[i]
+		key := alert.Key()
+
+		var text strings.Builder
+		if err := tpl.Execute(&text, alert); err != nil {
+			return result, fmt.Errorf("alert template: %w", err)
+		}
+
+		sent, isSent := p.alerts.Get(key)
+		if isSent && sent.text == text.String() && !sent.isPartial {
+			result.Unchanged++ //repeated notification of the firing alert
+			p.trackAlert(alert, key, sent)
+			continue
+		}
+
+		delivered, failed := 0, false
+		refs := make(map[string]MessageRef)
+		for receiver, ref := range sent.refs {
+			if sent.text == text.String() { //the retry of a partial delivery, this message is up to date
+				refs[receiver] = ref
+				delivered++
+				continue
+			}
+			if err := EditSent(conf, receiver, ref, text.String()); err != nil {
+				Rlog.Errorf("alert %s message edit in %s error, sending a new one: %v", key, receiver, err)
+				continue
+			}
+			refs[receiver] = ref
+			delivered++
+		}
+		edited := delivered
+
+		for _, receiver := range p.availableReceivers(conf, conf.Alerts.AlertReceivers(alert.Labels)) {
+			if _, ok := refs[receiver]; ok {
+				continue
+			}
+			ref, err := SendTo(conf, receiver, text.String(), nil)
+			if err != nil {
+				Rlog.Errorf("alert %s to %s error: %v", key, receiver, err)
+				errs = append(errs, fmt.Errorf("alert to %s: %w", receiver, err))
+				failed = true
+				continue
+			}
+			if len(ref) > 0 {
+				refs[receiver] = ref
+			}
+			delivered++
+		}
+
+		switch {
+		case delivered == 0 && failed:
+			continue
+		case delivered == 0:
+			Rlog.Infof("alert %s has no available receivers, dropped", key)
+			result.Dropped++
+			continue
+		case edited == delivered:
+			result.Edited++
+		default:
+			result.Sent++
+		}
+		//the refs that succeeded are kept, so the retried notification doesn't send them again
+		p.trackAlert(alert, key, sentAlert{refs: refs, text: text.String(), isPartial: failed})
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// trackAlert keeps the messages of the firing alert for the next updates, and forgets the resolved one
+// once it reached all the receivers
+func (p *Processor) trackAlert(alert *Alert, key string, sent sentAlert) {
+	if (alert.Status == AlertResolved && !sent.isPartial) || len(sent.refs) == 0 {
+		p.alerts.Delete(key)
+		return
+	}
+
+	sent.updatedAt = time.Now()
+	p.alerts.Set(key, sent)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeSink records the actions in place of a network
+type fakeSink struct {
+	caps  SinkCapabilities
+	calls []string
+}
+
+func (s *fakeSink) Capabilities() SinkCapabilities { return s.caps }
+
+func (s *fakeSink) SendText(dest string, text string) (MessageRef, error) {
+	s.calls = append(s.calls, "send "+text)
+	return MessageRef(fmt.Sprintf("%d", len(s.calls))), nil
+}
+
+func (s *fakeSink) SendAttachments(dest string, text string, attachments []DownloadedAttachment) (MessageRef, error) {
+	return s.SendText(dest, text)
+}
+
+func (s *fakeSink) Edit(dest string, ref MessageRef, text string) error {
+	s.calls = append(s.calls, fmt.Sprintf("edit %s %s", ref, text))
+	return nil
+}
+
+func (s *fakeSink) Delete(dest string, ref MessageRef) error {
+	s.calls = append(s.calls, fmt.Sprintf("delete %s", ref))
+	return nil
+}
+
+func (s *fakeSink) React(dest string, ref MessageRef, emoji string) error {
+	s.calls = append(s.calls, fmt.Sprintf("react %s %s", ref, emoji))
+	return nil
+}
+
+func TestLabelMatcher(t *testing.T) {
+	labels := map[string]string{"severity": "critical", "team": "db"}
+
+	tests := []struct {
+		matcher string
+		want    bool
+		wantErr bool
+	}{
+		{matcher: "severity=critical", want: true},
+		{matcher: `severity = "critical"`, want: true},
+		{matcher: "severity!=critical", want: false},
+		{matcher: "env!=prod", want: true},
+		{matcher: "team=~db|infra", want: true},
+		{matcher: "team=~d", want: false}, //anchored like in Alertmanager
+		{matcher: "team!~web.*", want: true},
+		{matcher: "env=", want: true}, //a missing label is empty
+		{matcher: "team=~(", wantErr: true},
+		{matcher: "no matcher", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.matcher, func(t *testing.T) {
+			m, err := ParseLabelMatcher(tt.matcher)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && m.Match(labels) != tt.want {
+				t.Errorf("match = %v, want %v", !tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlertReceivers(t *testing.T) {
+	conf := &Config{CLIAddress: "localhost:8080", SelfNumber: "+10000000000", Alerts: &AlertsConfig{
+		IsEnabled:         true,
+		ReceiversGroupIds: []string{"default"},
+		Routes: []AlertRouteConfig{
+			{Matchers: []string{"severity=critical", "team=~db|infra"}, ReceiversGroupIds: []string{"oncall"}, StopOnMatch: true},
+			{Matchers: []string{"severity=~critical|warning"}, ReceiversGroupIds: []string{"alerts"}},
+			{Matchers: []string{"team=db"}, ReceiversGroupIds: []string{"db"}},
+		},
+	}}
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("validate error: %v", err)
+	}
+	for i, route := range conf.Alerts.Routes {
+		if len(route.matchers) != len(route.Matchers) {
+			t.Fatalf("route %d matchers are not parsed by the validation", i+1)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string
+	}{
+		{name: "stop on match", labels: map[string]string{"severity": "critical", "team": "db"}, want: []string{"oncall"}},
+		{name: "every matching route", labels: map[string]string{"severity": "warning", "team": "db"}, want: []string{"alerts", "db"}},
+		{name: "default receivers", labels: map[string]string{"severity": "info"}, want: []string{"default"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := conf.Alerts.AlertReceivers(tt.labels)
+			if len(got) != len(tt.want) {
+				t.Fatalf("receivers = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("receivers = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	invalid := AlertRouteConfig{Matchers: []string{"team=~("}}
+	if invalid.Match(map[string]string{"team": "("}) {
+		t.Error("route with an invalid matcher matches")
+	}
+}
+
+func TestHandleAlertsEditFallback(t *testing.T) {
+	sinks := map[string]*fakeSink{
+		DestMatrix:   {caps: SinkCapabilities{}}, //no edits, the update is a new message
+		DestTelegram: {caps: SinkCapabilities{Edit: true}},
+	}
+	defer func(f func(*Config, string) (Sink, error)) { newSink = f }(newSink)
+	newSink = func(conf *Config, kind string) (Sink, error) {
+		return sinks[kind], nil
+	}
+
+	conf := &Config{IsSendingEnabled: true, Alerts: &AlertsConfig{
+		IsEnabled:         true,
+		Template:          "{{.Status}} {{.Labels.alertname}}",
+		ReceiversGroupIds: []string{"matrix:!room:example.org", "telegram:1"},
+	}}
+	p := &Processor{activity: new(Activity), alerts: NewAlertTracker(), unavailable: make(map[string]string)}
+	p.conf.Store(conf)
+
+	alert := Alert{Status: AlertFiring, Labels: map[string]string{"alertname": "DiskFull"}, Fingerprint: "f1"}
+	if result, err := p.HandleAlerts(&AlertmanagerPayload{Alerts: []Alert{alert}}); err != nil || result.Sent != 1 {
+		t.Fatalf("firing = %+v, %v", result, err)
+	}
+
+	alert.Status = AlertResolved
+	if result, err := p.HandleAlerts(&AlertmanagerPayload{Alerts: []Alert{alert}}); err != nil || result.Sent != 1 {
+		t.Fatalf("resolved = %+v, %v", result, err)
+	}
+
+	want := map[string]string{
+		DestMatrix:   "send firing DiskFull,send resolved DiskFull",
+		DestTelegram: "send firing DiskFull,edit 1 resolved DiskFull",
+	}
+	for kind, sink := range sinks {
+		if got := strings.Join(sink.calls, ","); got != want[kind] {
+			t.Errorf("%s calls = %q, want %q", kind, got, want[kind])
+		}
+	}
+}
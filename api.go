@@ -1,32 +1,129 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type API struct {
-	r    *mux.Router
-	conf *Config
+	r           *mux.Router
+	private     *mux.Router //the endpoints requiring the api auth
+	p           *Processor
+	sendLimiter RateLimiter
 }
 
-func newAPI(conf *Config) *API {
+func newAPI(p *Processor) *API {
 	api := &API{
-		r:    mux.NewRouter(),
-		conf: conf,
+		r: mux.NewRouter().UseEncodedPath(),
+		p: p,
 	}
 
 	return api
 }
 
 func (api *API) ConfigureRoutes() error {
+	api.registerRoutes()
+
+	return api.serve()
+}
+
+func (api *API) registerRoutes() {
+	//public
 	api.r.HandleFunc("/", api.HomeHandler).Methods("GET")
 	api.r.HandleFunc("/health", api.HealthHandler).Methods("GET")
-	api.r.HandleFunc("/groups", api.GroupsHandler).Methods("GET")
+	api.configureWebhookRoutes(api.r) //authenticated with the webhook secrets
+
+	//private
+	private := api.r.NewRoute().Subrouter()
+	private.Use(api.authMiddleware)
+	api.private = private
+	private.HandleFunc("/groups", api.GroupsHandler).Methods("GET")
+	private.HandleFunc("/contacts", api.ContactsHandler).Methods("GET")
+	private.HandleFunc("/directory/refresh", api.DirectoryRefreshHandler).Methods("POST")
 	//api.r.HandleFunc("/groups_html", ArticlesHandler).Methods("GET")
+	api.configureAdminRoutes(private)
+	api.configureArchiveRoutes(private)
+	api.configureSendRoutes(private)
+	api.configureAlertsRoutes(private)
+	api.configureDashboardRoutes(private)
+}
+
+func (api *API) serve() error {
+	conf := api.p.Config().Api
+	if !conf.HasAuth() {
+		Rlog.Errorf("WARNING: api auth is not configured, these endpoints are disabled and return 404: %s. "+
+			"Set api auth_token or basic_auth_user and basic_auth_password to enable them.", strings.Join(api.privateEndpoints(), ", "))
+	}
+
+	server := &http.Server{
+		Addr:              conf.Addr(),
+		Handler:           api.r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if conf.IsTLS() {
+		Rlog.Infof("api listening on https://%s", server.Addr)
+		return server.ListenAndServeTLS(conf.TLSCertFile, conf.TLSKeyFile)
+	}
+
+	Rlog.Infof("api listening on http://%s", server.Addr)
+	return server.ListenAndServe()
+}
+
+// privateEndpoints lists the paths of the private routes, like "GET /groups"
+func (api *API) privateEndpoints() []string {
+	var endpoints []string
+	_ = api.private.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || route.GetHandler() == nil {
+			return nil //the subrouters, like /admin
+		}
+		methods, _ := route.GetMethods()
+		endpoints = append(endpoints, strings.TrimSpace(strings.Join(methods, "|")+" "+path))
 
-	return http.ListenAndServe(":8181", api.r)
+		return nil
+	})
+
+	return endpoints
+}
+
+// authMiddleware checks the bearer token or the basic auth credentials; without any of them configured
+// the private endpoints are disabled
+func (api *API) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := api.p.Config().Api
+		if !conf.HasAuth() {
+			writeJSONError(w, http.StatusNotFound, errors.New("private api is disabled without api auth"))
+			return
+		}
+
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && len(conf.AuthToken) > 0 {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(conf.AuthToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if user, password, ok := r.BasicAuth(); ok && len(conf.BasicAuthUser) > 0 {
+			userOk := subtle.ConstantTimeCompare([]byte(user), []byte(conf.BasicAuthUser)) == 1
+			passwordOk := subtle.ConstantTimeCompare([]byte(password), []byte(conf.BasicAuthPassword)) == 1
+			if userOk && passwordOk {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if len(conf.BasicAuthUser) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ReplicatorGo"`)
+		}
+		Rlog.Infof("unauthorized api request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	})
 }
 
 func (api *API) HomeHandler(w http.ResponseWriter, r *http.Request) {
@@ -35,7 +132,7 @@ func (api *API) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := w.Write([]byte("Signal Bot\n"))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		Rlog.Error("HomeHandler Write Error: %v", err)
+		Rlog.Errorf("HomeHandler Write Error: %v", err)
 	}
 }
 
@@ -43,36 +140,44 @@ func (api *API) HealthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
-	healthResponse := make(map[string]string)
+	//the endpoint is public, so only the number of the unavailable receivers is shown, see /status for details
+	healthResponse := make(map[string]any)
 	healthResponse["status"] = "UP"
+	if unavailable := len(api.p.ReceiversStatus()); unavailable > 0 {
+		healthResponse["status"] = "DEGRADED"
+		healthResponse["unavailable_receivers"] = unavailable
+	}
 
 	resp, _ := json.Marshal(healthResponse)
 
 	_, err := w.Write(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		Rlog.Error("HealthHandler Write Error: %v", err)
+		Rlog.Errorf("HealthHandler Write Error: %v", err)
 	}
 }
 
 func (api *API) GroupsHandler(w http.ResponseWriter, r *http.Request) {
-	groups, err := GetGroupsList(api.conf)
+	groups, err := api.p.Groups()
 	if err != nil {
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusInternalServerError)
-		Rlog.Errorf("GroupsHandler GetGroupsList Error: %v", err)
+		Rlog.Errorf("GroupsHandler Groups Error: %v", err)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	groupsResponse := make([]map[string]string, len(groups))
+	groupsResponse := make([]map[string]any, len(groups))
 	for i, group := range groups {
-		groupsResponse[i] = make(map[string]string)
+		groupsResponse[i] = make(map[string]any)
 		groupsResponse[i]["name"] = group.Name
 		groupsResponse[i]["id"] = group.Id
 		groupsResponse[i]["internal_id"] = group.InternalId
+		groupsResponse[i]["members"] = group.Members
+		groupsResponse[i]["admins"] = group.Admins
+		groupsResponse[i]["blocked"] = group.Blocked
 	}
 
 	resp, err := json.Marshal(groupsResponse)
@@ -87,3 +192,27 @@ func (api *API) GroupsHandler(w http.ResponseWriter, r *http.Request) {
 		Rlog.Errorf("GroupsHandler Write Error: %v", err)
 	}
 }
+
+func (api *API) ContactsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := api.p.Groups(); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.p.directory.Contacts())
+}
+
+// DirectoryRefreshHandler refreshes the groups and contacts without waiting for the TTL
+func (api *API) DirectoryRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	if err := api.p.RefreshDirectory(); err != nil {
+		Rlog.Errorf("DirectoryRefreshHandler Error: %v", err)
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"groups":     len(api.p.directory.Groups()),
+		"contacts":   len(api.p.directory.Contacts()),
+		"updated_at": api.p.directory.UpdatedAt(),
+	})
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+type (
+	// adminRule is the rule as the admin and status endpoints show it, its webhooks are redacted
+	adminRule struct {
+		Number int `json:"number"`
+		ConfigGroup
+		Webhooks      []adminWebhook `json:"webhooks,omitempty"`        //replaces the rule's webhooks in the output
+		SourceGroupId string         `json:"source_group_id,omitempty"` //resolved from the group name when it is used
+		Receivers     []string       `json:"receivers,omitempty"`       //all receivers group ids, including the routes and the resolved names
+	}
+	// adminWebhook is the webhook without the secret and the header values, they may hold credentials
+	adminWebhook struct {
+		URL         string   `json:"url"`
+		HasSecret   bool     `json:"has_secret,omitempty"`
+		TimeoutSec  int      `json:"timeout_sec,omitempty"`
+		Retries     int      `json:"retries,omitempty"`
+		Attachments string   `json:"attachments,omitempty"`
+		URLTTLSec   int      `json:"url_ttl_sec,omitempty"`
+		Headers     []string `json:"headers,omitempty"` //names only
+	}
+	adminSendingRequest struct {
+		IsSendingEnabled *bool `json:"is_sending_enabled"`
+	}
+)
+
+func newAdminRule(number int, rec ConfigGroup) adminRule {
+	rule := adminRule{Number: number, ConfigGroup: rec, SourceGroupId: rec.SourceGroupId(), Receivers: rec.AllReceivers()}
+	rule.ConfigGroup.Webhooks = nil
+	for _, wc := range rec.Webhooks {
+		wh := adminWebhook{URL: wc.URL, HasSecret: len(wc.Secret) > 0, TimeoutSec: wc.TimeoutSec, Retries: wc.Retries, Attachments: wc.Attachments, URLTTLSec: wc.URLTTLSec}
+		for name := range wc.Headers {
+			wh.Headers = append(wh.Headers, name)
+		}
+		sort.Strings(wh.Headers)
+		rule.Webhooks = append(rule.Webhooks, wh)
+	}
+
+	return rule
+}
+
+// keepWebhookSecrets copies the secret and the headers of the current rule's webhooks to the updated ones
+// of the same url sent without them, the admin endpoints never show them
+func keepWebhookSecrets(rec *ConfigGroup, current ConfigGroup) {
+	for i := range rec.Webhooks {
+		wc := &rec.Webhooks[i]
+		for _, old := range current.Webhooks {
+			if old.URL != wc.URL {
+				continue
+			}
+			if len(wc.Secret) == 0 {
+				wc.Secret = old.Secret
+			}
+			if len(wc.Headers) == 0 {
+				wc.Headers = old.Headers
+			}
+			break
+		}
+	}
+}
+
+func (api *API) configureAdminRoutes(private *mux.Router) {
+	admin := private.PathPrefix("/admin").Subrouter()
+
+	admin.HandleFunc("/rules", api.AdminRulesHandler).Methods("GET")
+	admin.HandleFunc("/rules", api.AdminCreateRuleHandler).Methods("POST")
+	admin.HandleFunc("/rules/{rule}", api.AdminRuleHandler).Methods("GET")
+	admin.HandleFunc("/rules/{rule}", api.AdminUpdateRuleHandler).Methods("PUT")
+	admin.HandleFunc("/rules/{rule}", api.AdminDeleteRuleHandler).Methods("DELETE")
+	admin.HandleFunc("/rules/{rule}/enable", api.AdminEnableRuleHandler(true)).Methods("POST")
+	admin.HandleFunc("/rules/{rule}/disable", api.AdminEnableRuleHandler(false)).Methods("POST")
+	admin.HandleFunc("/sending", api.AdminSendingHandler).Methods("PUT")
+	admin.HandleFunc("/config/validate", api.AdminValidateConfigHandler).Methods("POST")
+}
+
+func (api *API) AdminRulesHandler(w http.ResponseWriter, r *http.Request) {
+	conf := api.p.Config()
+
+	rules := make([]adminRule, len(conf.Forwarding))
+	for i, rec := range conf.Forwarding {
+		rules[i] = newAdminRule(i+1, rec)
+	}
+
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (api *API) AdminRuleHandler(w http.ResponseWriter, r *http.Request) {
+	conf := api.p.Config()
+
+	i, err := conf.FindRule(ruleRef(r))
+	if err != nil {
+		writeJSONError(w, adminErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAdminRule(i+1, conf.Forwarding[i]))
+}
+
+func (api *API) AdminCreateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rec ConfigGroup
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var number int
+	_, err := api.p.UpdateConfig(func(c *Config) error {
+		if c.FindGroupRule(&rec) >= 0 {
+			return fmt.Errorf("%w: rule for group %s already exists", ErrInvalidConfig, rec.Ref())
+		}
+		c.Forwarding = append(c.Forwarding, rec)
+		number = len(c.Forwarding)
+
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, adminErrorStatus(err), err)
+		return
+	}
+
+	Rlog.Infof("admin api: rule %d for group %s created", number, rec.Ref())
+	api.writeRule(w, http.StatusCreated, number)
+}
+
+func (api *API) AdminUpdateRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rec ConfigGroup
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var number int
+	_, err := api.p.UpdateConfig(func(c *Config) error {
+		i, err := c.FindRule(ruleRef(r))
+		if err != nil {
+			return err
+		}
+		if j := c.FindGroupRule(&rec); j >= 0 && j != i {
+			return fmt.Errorf("%w: rule for group %s already exists", ErrInvalidConfig, rec.Ref())
+		}
+		keepWebhookSecrets(&rec, c.Forwarding[i])
+		c.Forwarding[i] = rec
+		number = i + 1
+
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, adminErrorStatus(err), err)
+		return
+	}
+
+	Rlog.Infof("admin api: rule %d for group %s updated", number, rec.Ref())
+	api.writeRule(w, http.StatusOK, number)
+}
+
+func (api *API) AdminDeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var groupId string
+	_, err := api.p.UpdateConfig(func(c *Config) error {
+		i, err := c.FindRule(ruleRef(r))
+		if err != nil {
+			return err
+		}
+		groupId = c.Forwarding[i].Ref()
+		c.Forwarding = append(c.Forwarding[:i], c.Forwarding[i+1:]...)
+
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, adminErrorStatus(err), err)
+		return
+	}
+
+	Rlog.Infof("admin api: rule for group %s deleted", groupId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) AdminEnableRuleHandler(isEnabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var number int
+		_, err := api.p.UpdateConfig(func(c *Config) error {
+			i, err := c.FindRule(ruleRef(r))
+			if err != nil {
+				return err
+			}
+			c.Forwarding[i].IsEnabled = isEnabled
+			number = i + 1
+
+			return nil
+		})
+		if err != nil {
+			writeJSONError(w, adminErrorStatus(err), err)
+			return
+		}
+
+		Rlog.Infof("admin api: rule %d is %s", number, onOff(isEnabled))
+		api.writeRule(w, http.StatusOK, number)
+	}
+}
+
+func (api *API) AdminSendingHandler(w http.ResponseWriter, r *http.Request) {
+	var req adminSendingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.IsSendingEnabled == nil {
+		writeJSONError(w, http.StatusBadRequest, errors.New("is_sending_enabled is required"))
+		return
+	}
+
+	conf, err := api.p.UpdateConfig(func(c *Config) error {
+		c.IsSendingEnabled = *req.IsSendingEnabled
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, adminErrorStatus(err), err)
+		return
+	}
+
+	Rlog.Infof("admin api: sending is %s", onOff(conf.IsSendingEnabled))
+	writeJSON(w, http.StatusOK, adminSendingRequest{IsSendingEnabled: &conf.IsSendingEnabled})
+}
+
+// AdminValidateConfigHandler checks the proposed config without applying it, resolving the group names
+// like the config update does
+func (api *API) AdminValidateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var conf Config
+	if err := json.NewDecoder(r.Body).Decode(&conf); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := api.p.CheckConfig(&conf); err != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"valid": false, "error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true})
+}
+
+func (api *API) writeRule(w http.ResponseWriter, status int, number int) {
+	conf := api.p.Config()
+	if number < 1 || number > len(conf.Forwarding) {
+		writeJSONError(w, http.StatusConflict, errors.New("rule changed concurrently"))
+		return
+	}
+
+	writeJSON(w, status, newAdminRule(number, conf.Forwarding[number-1]))
+}
+
+// ruleRef is the rule number or group id from the path; group ids may contain "/", so they come url-encoded
+func ruleRef(r *http.Request) string {
+	ref := mux.Vars(r)["rule"]
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		return unescaped
+	}
+
+	return ref
+}
+
+func adminErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidConfig):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		Rlog.Errorf("json.Marshal Error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(resp)
+	if err != nil {
+		Rlog.Errorf("Write Error: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+const alertsMaxBodySize = 10 << 20
+
+func (api *API) configureAlertsRoutes(private *mux.Router) {
+	private.HandleFunc("/alerts", api.AlertsHandler).Methods("POST")
+}
+
+// AlertsHandler accepts the Alertmanager (or Grafana alerting) webhook notifications and posts the alerts to the groups
+func (api *API) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	conf := api.p.Config()
+	if conf.Alerts == nil || !conf.Alerts.IsEnabled {
+		writeJSONError(w, http.StatusNotFound, errors.New("alerts are disabled"))
+		return
+	}
+	if !conf.IsSendingEnabled {
+		writeJSONError(w, http.StatusServiceUnavailable, errors.New("sending messages disabled"))
+		return
+	}
+
+	var payload AlertmanagerPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, alertsMaxBodySize)).Decode(&payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := api.p.HandleAlerts(&payload)
+	if err != nil {
+		Rlog.Errorf("AlertsHandler HandleAlerts Error: %v", err)
+		writeJSONError(w, http.StatusBadGateway, err) //Alertmanager retries on 5xx
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
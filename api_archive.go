@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (api *API) configureArchiveRoutes(private *mux.Router) {
+	private.HandleFunc("/archive/search", api.ArchiveSearchHandler).Methods("GET")
+}
+
+// ArchiveSearchHandler searches the archive by group (id or name), sender (uuid or a part of the name),
+// kind (received or sent), time range (from, to in RFC 3339) and text (q), newest first
+func (api *API) ArchiveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if api.p.archive == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("archive is disabled"))
+		return
+	}
+
+	params := r.URL.Query()
+	q := ArchiveQuery{
+		GroupId: params.Get("group"),
+		Sender:  params.Get("sender"),
+		Kind:    params.Get("kind"),
+		Text:    params.Get("q"),
+	}
+
+	if len(q.GroupId) > 0 {
+		if _, ok := api.p.directory.Group(q.GroupId); !ok {
+			if id, err := api.p.directory.ResolveGroupName(q.GroupId); err == nil {
+				q.GroupId = id
+			}
+		}
+	}
+
+	var err error
+	if q.From, err = parseQueryTime(params.Get("from")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("from: %w", err))
+		return
+	}
+	if q.To, err = parseQueryTime(params.Get("to")); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("to: %w", err))
+		return
+	}
+	if limit := params.Get("limit"); len(limit) > 0 {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("limit: %w", err))
+			return
+		}
+	}
+
+	entries, err := api.p.archive.Search(q)
+	if err != nil {
+		Rlog.Errorf("ArchiveSearchHandler Search Error: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func parseQueryTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
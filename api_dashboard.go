@@ -0,0 +1,51 @@
+package main
+
+import (
+	"embed"
+	"github.com/gorilla/mux"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+type statusResponse struct {
+	StartedAt        time.Time        `json:"started_at"`
+	IsSendingEnabled bool             `json:"is_sending_enabled"`
+	Activity         ActivityStatus   `json:"activity"`
+	Rules            []adminRule      `json:"rules"`
+	DigestsPending   map[string]int   `json:"digests_pending"`
+	Unavailable      []ReceiverStatus `json:"unavailable_receivers"`
+}
+
+func (api *API) configureDashboardRoutes(private *mux.Router) {
+	static, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		Rlog.Fatal("dashboard files error: ", err)
+	}
+
+	private.HandleFunc("/status", api.StatusHandler).Methods("GET")
+	private.Handle("/dashboard", http.RedirectHandler("/dashboard/", http.StatusMovedPermanently)).Methods("GET")
+	private.PathPrefix("/dashboard/").Handler(http.StripPrefix("/dashboard/", http.FileServer(http.FS(static)))).Methods("GET")
+}
+
+// StatusHandler returns the bot state shown by the dashboard
+func (api *API) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	conf := api.p.Config()
+
+	rules := make([]adminRule, len(conf.Forwarding))
+	for i, rec := range conf.Forwarding {
+		rules[i] = newAdminRule(i+1, rec)
+	}
+
+	writeJSON(w, http.StatusOK, statusResponse{
+		StartedAt:        api.p.startedAt,
+		IsSendingEnabled: conf.IsSendingEnabled,
+		Activity:         api.p.activity.Status(),
+		Rules:            rules,
+		DigestsPending:   api.p.digests.Pending(),
+		Unavailable:      api.p.ReceiversStatus(),
+	})
+}
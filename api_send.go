@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sendMaxBodySize   = 100 << 20 //Signal's attachment size limit
+	sendMaxFormMemory = 32 << 20
+)
+
+type (
+	sendRequest struct {
+		Text        string                  `json:"text"`
+		Groups      []string                `json:"groups"` //group ids or names, or other networks' destinations like telegram:<chat_id>
+		Attachments []sendRequestAttachment `json:"attachments,omitempty"`
+	}
+	sendRequestAttachment struct {
+		Filename    string `json:"filename,omitempty"`
+		ContentType string `json:"content_type,omitempty"` //detected from the content by default
+		Data        string `json:"data"`                   //base64
+	}
+	sendResponse struct {
+		Timestamp uint64                `json:"timestamp,omitempty"` //Signal timestamp of the message sent to the first group
+		Groups    []string              `json:"groups"`
+		Messages  map[string]MessageRef `json:"messages"` //references of the sent messages by destination, to edit or delete them later
+	}
+)
+
+func (api *API) configureSendRoutes(private *mux.Router) {
+	private.HandleFunc("/send", api.SendHandler).Methods("POST")
+}
+
+// SendHandler posts the text and the attachments to the groups as the bot, accepting JSON
+// (attachments in base64) or multipart/form-data (text, group and attachment fields)
+func (api *API) SendHandler(w http.ResponseWriter, r *http.Request) {
+	conf := api.p.Config()
+	if !conf.IsSendingEnabled {
+		writeJSONError(w, http.StatusServiceUnavailable, errors.New("sending messages disabled"))
+		return
+	}
+	if ok, wait := api.sendLimiter.Allow(conf.Api.SendRate(), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, errors.New("send rate limit exceeded"))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, sendMaxBodySize)
+	req, attachments, err := parseSendRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(strings.TrimSpace(req.Text)) == 0 && len(attachments) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("text or attachments are required"))
+		return
+	}
+	if len(req.Groups) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("at least one group is required"))
+		return
+	}
+
+	groupIds := make([]string, 0, len(req.Groups))
+	for _, ref := range req.Groups {
+		id, err := api.resolveSendGroup(conf, strings.TrimSpace(ref))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if IsSignalReceiver(id) {
+			if problem := api.p.directory.ReceiverProblem(id, conf.SelfNumber); len(problem) > 0 {
+				writeJSONError(w, http.StatusUnprocessableEntity, fmt.Errorf("group %s: %s", ref, problem))
+				return
+			}
+		}
+		groupIds = appendUnique(groupIds, id)
+	}
+
+	var (
+		timestamp uint64
+		errs      []error
+	)
+	messages := make(map[string]MessageRef, len(groupIds))
+	for _, id := range groupIds {
+		ref, err := SendTo(conf, id, req.Text, attachments)
+		if err != nil {
+			Rlog.Errorf("SendHandler send to %s error: %v", id, err)
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		messages[id] = ref
+		if timestamp == 0 && IsSignalReceiver(id) {
+			timestamp, _ = strconv.ParseUint(string(ref), 10, 64)
+		}
+	}
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": errors.Join(errs...).Error(), "messages": messages})
+		return
+	}
+
+	names := make([]string, len(attachments))
+	for i, a := range attachments {
+		names[i] = attachmentName(a.SignalAttachments)
+	}
+	err = api.p.archive.Add(ArchiveEntry{Kind: ArchiveSent, Timestamp: timestamp, Text: req.Text, Attachments: names, Receivers: groupIds})
+	if err != nil {
+		Rlog.Error("archive error: ", err)
+	}
+
+	writeJSON(w, http.StatusOK, sendResponse{Timestamp: timestamp, Groups: groupIds, Messages: messages})
+}
+
+// resolveSendGroup accepts a known group id, a group name or another network's destination; any id is accepted
+// until the groups are loaded
+func (api *API) resolveSendGroup(conf *Config, ref string) (string, error) {
+	if len(ref) == 0 {
+		return "", errors.New("group must not be empty")
+	}
+	kind, address := SplitDestination(ref)
+	if kind != DestSignal {
+		return ref, conf.validateDestination(ref)
+	}
+	ref = strings.TrimSpace(address) //without the optional signal: prefix
+	if _, ok := api.p.directory.Group(ref); ok {
+		return ref, nil
+	}
+
+	id, err := api.p.directory.ResolveGroupName(ref)
+	if err != nil && api.p.directory.UpdatedAt().IsZero() {
+		return ref, nil
+	}
+
+	return id, err
+}
+
+func parseSendRequest(r *http.Request) (sendRequest, []DownloadedAttachment, error) {
+	var req sendRequest
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(sendMaxFormMemory); err != nil {
+			return req, nil, err
+		}
+		req.Text = r.FormValue("text")
+		req.Groups = r.MultipartForm.Value["group"]
+
+		attachments := make([]DownloadedAttachment, 0, len(r.MultipartForm.File["attachment"]))
+		for _, header := range r.MultipartForm.File["attachment"] {
+			f, err := header.Open()
+			if err != nil {
+				return req, nil, err
+			}
+			content, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return req, nil, err
+			}
+			attachments = append(attachments, newSentAttachment(header.Filename, header.Header.Get("Content-Type"), content))
+		}
+
+		return req, attachments, nil
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, nil, err
+	}
+
+	attachments := make([]DownloadedAttachment, 0, len(req.Attachments))
+	for i, a := range req.Attachments {
+		content, err := base64.StdEncoding.DecodeString(a.Data)
+		if err != nil {
+			return req, nil, fmt.Errorf("attachment %d: %w", i+1, err)
+		}
+		attachments = append(attachments, newSentAttachment(a.Filename, a.ContentType, content))
+	}
+
+	return req, attachments, nil
+}
+
+func newSentAttachment(filename, contentType string, content []byte) DownloadedAttachment {
+	if len(contentType) == 0 || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(content)
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType //the parameters would break the data uri
+	}
+
+	return DownloadedAttachment{
+		SignalAttachments: SignalAttachments{ContentType: contentType, Filename: filename, Size: uint64(len(content))},
+		Content:           content,
+	}
+}
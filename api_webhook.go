@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gorilla/mux"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (api *API) configureWebhookRoutes(r *mux.Router) {
+	r.HandleFunc(webhookFilesPath+"{id}", api.WebhookFileHandler).Methods("GET")
+}
+
+// WebhookFileHandler serves the prepared attachment linked from a webhook payload, to the requests with
+// the webhook's secret as the bearer token, until the link expires
+func (api *API) WebhookFileHandler(w http.ResponseWriter, r *http.Request) {
+	file, ok := api.p.webhookFiles.Get(mux.Vars(r)["id"], time.Now())
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errors.New("file is not found or the link has expired"))
+		return
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(file.secret)) != 1 {
+		Rlog.Infof("unauthorized webhook file request from %s", r.RemoteAddr)
+		writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	a := file.attachment
+	contentType := a.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(a.Content)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachmentName(a.SignalAttachments)}))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(a.Content); err != nil {
+		Rlog.Errorf("WebhookFileHandler Write Error: %v", err)
+	}
+}
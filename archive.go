@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveReceived = "received"
+	ArchiveSent     = "sent"
+
+	archivePurgeInterval    = time.Hour
+	archiveSearchLimit      = 50
+	archiveSearchLimitMax   = 500
+	archiveOpenTimeout      = 5 * time.Second
+	archiveEntriesBucketKey = "entries"
+)
+
+type (
+	// ArchiveEntry is a received message with its routing decision, or a copy sent by the bot.
+	// Phone numbers are not archived, authors are kept as the rule's author_display shows them.
+	ArchiveEntry struct {
+		Id          uint64    `json:"id"`
+		Time        time.Time `json:"time"`
+		Kind        string    `json:"kind"`
+		GroupId     string    `json:"group_id,omitempty"`
+		SourceUuid  string    `json:"source_uuid,omitempty"`
+		SourceName  string    `json:"source_name,omitempty"`
+		Timestamp   uint64    `json:"timestamp,omitempty"` //Signal timestamp of the received message
+		Text        string    `json:"text,omitempty"`
+		Attachments []string  `json:"attachments,omitempty"` //file names or content types
+		Decision    string    `json:"decision,omitempty"`
+		Receivers   []string  `json:"receivers,omitempty"`
+	}
+
+	ArchiveQuery struct {
+		GroupId string
+		Sender  string //uuid or a part of the name
+		Kind    string
+		From    time.Time
+		To      time.Time
+		Text    string //all the words must be in the text, case-insensitive
+		Limit   int
+	}
+
+	// Archive keeps the entries in a bbolt file, in the order they are added
+	Archive struct {
+		db        *bolt.DB
+		retention time.Duration
+	}
+)
+
+var archiveEntries = []byte(archiveEntriesBucketKey)
+
+func OpenArchive(conf *ArchiveConfig, path string) (*Archive, error) {
+	if conf == nil || !conf.IsEnabled {
+		return nil, nil
+	}
+
+	db, err := bolt.Open(path, 0o640, &bolt.Options{Timeout: archiveOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(archiveEntries)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	Rlog.Infof("archive is opened at %s", path)
+
+	return &Archive{db: db, retention: time.Duration(conf.RetentionDays) * 24 * time.Hour}, nil
+}
+
+func (a *Archive) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	return a.db.Close()
+}
+
+func (a *Archive) Add(entry ArchiveEntry) error {
+	if a == nil {
+		return nil
+	}
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(archiveEntries)
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.Id = id
+		if entry.Time.IsZero() {
+			entry.Time = time.Now().UTC()
+		}
+
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(archiveKey(id), value)
+	})
+}
+
+// Search returns the entries matching the query, newest first
+func (a *Archive) Search(q ArchiveQuery) ([]ArchiveEntry, error) {
+	if a == nil {
+		return nil, errors.New("archive is disabled")
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = archiveSearchLimit
+	}
+	q.Limit = min(q.Limit, archiveSearchLimitMax)
+	words := strings.Fields(strings.ToLower(q.Text))
+
+	entries := make([]ArchiveEntry, 0)
+	err := a.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(archiveEntries).Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < q.Limit; k, v = c.Prev() {
+			var entry ArchiveEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if !q.From.IsZero() && entry.Time.Before(q.From) {
+				break //entries are in the time order
+			}
+			if q.Match(&entry, words) {
+				entries = append(entries, entry)
+			}
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Match checks the entry against the query, except the time lower bound used to stop the search
+func (q *ArchiveQuery) Match(entry *ArchiveEntry, words []string) bool {
+	if !q.To.IsZero() && entry.Time.After(q.To) {
+		return false
+	}
+	if len(q.GroupId) > 0 && !strings.EqualFold(entry.GroupId, q.GroupId) {
+		return false
+	}
+	if len(q.Kind) > 0 && entry.Kind != q.Kind {
+		return false
+	}
+	if len(q.Sender) > 0 && !strings.EqualFold(entry.SourceUuid, q.Sender) &&
+		!strings.Contains(strings.ToLower(entry.SourceName), strings.ToLower(q.Sender)) {
+		return false
+	}
+
+	text := strings.ToLower(entry.Text)
+	for _, word := range words {
+		if !strings.Contains(text, word) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Purge deletes the entries older than the retention period, returning how many were deleted
+func (a *Archive) Purge(now time.Time) (int, error) {
+	if a == nil || a.retention <= 0 {
+		return 0, nil
+	}
+
+	deadline := now.Add(-a.retention)
+	deleted := 0
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(archiveEntries).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var entry ArchiveEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if !entry.Time.Before(deadline) {
+				break
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted++
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+func (a *Archive) purgePeriodically(stop chan struct{}) {
+	ticker := time.NewTicker(archivePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if deleted, err := a.Purge(time.Now()); err != nil {
+			Rlog.Error("archive purge error: ", err)
+		} else if deleted > 0 {
+			Rlog.Infof("archive purge: %d old entries deleted", deleted)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func archiveKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+
+	return key
+}
+
+func archiveAttachments(attachments []SignalAttachments) []string {
+	names := make([]string, 0, len(attachments))
+	for _, a := range attachments {
+		name := a.Filename
+		if len(name) == 0 {
+			name = a.ContentType
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// archiveReceived keeps the message of a configured group with the decision taken for it
+func (p *Processor) archiveReceived(env *SignalEnvelope, decision RoutingDecision) {
+	if p.archive == nil || decision.Rule == nil {
+		return
+	}
+
+	err := p.archive.Add(ArchiveEntry{
+		Kind:        ArchiveReceived,
+		GroupId:     env.DataMessage.GroupInfo.GroupId,
+		SourceUuid:  AuthorUuid(decision.Rule, env),
+		SourceName:  p.AuthorName(decision.Rule, env),
+		Timestamp:   env.Timestamp,
+		Text:        env.DataMessage.Message,
+		Attachments: archiveAttachments(env.DataMessage.Attachments),
+		Decision:    decision.String(),
+	})
+	if err != nil {
+		Rlog.Error("archive error: ", err)
+	}
+}
+
+// archiveSent keeps the copy of the message sent by the bot
+func (p *Processor) archiveSent(decision RoutingDecision, receivers []string) {
+	if p.archive == nil {
+		return
+	}
+
+	err := p.archive.Add(ArchiveEntry{
+		Kind:        ArchiveSent,
+		GroupId:     decision.Rule.SourceGroupId(),
+		Text:        decision.Text,
+		Attachments: archiveAttachments(decision.Attachments),
+		Receivers:   receivers,
+	})
+	if err != nil {
+		Rlog.Error("archive error: ", err)
+	}
+}
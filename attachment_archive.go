@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultArchivePattern = "{group}/{date}/{sender}"
+	archiveIndexFile      = ".index.json"
+)
+
+var ErrArchiveFull = errors.New("attachment archive is over its max size")
+
+type (
+	// AttachmentMeta is the JSON sidecar saved next to an archived attachment
+	AttachmentMeta struct {
+		GroupId     string    `json:"group_id"`
+		GroupName   string    `json:"group_name,omitempty"`
+		Sender      string    `json:"sender,omitempty"` //as the rule's author_display allows
+		SenderUuid  string    `json:"sender_uuid,omitempty"`
+		Timestamp   uint64    `json:"timestamp"`
+		ArchivedAt  time.Time `json:"archived_at"`
+		Message     string    `json:"message,omitempty"`
+		Caption     string    `json:"caption,omitempty"`
+		Filename    string    `json:"filename,omitempty"`
+		ContentType string    `json:"content_type,omitempty"`
+		Size        int64     `json:"size"`
+		Sha256      string    `json:"sha256"`
+		DuplicateOf string    `json:"duplicate_of,omitempty"` //the file with the same content, archived earlier
+	}
+
+	// attachmentArchiveDir is the state of one archive_dir: the content hashes index and the disk usage
+	attachmentArchiveDir struct {
+		index map[string]string //sha256 -> file path relative to the dir
+		usage int64
+	}
+
+	// AttachmentArchive saves the attachments of the rules with archive_dir, deduplicated by content hash
+	AttachmentArchive struct {
+		mu   sync.Mutex
+		dirs map[string]*attachmentArchiveDir
+	}
+)
+
+func NewAttachmentArchive() *AttachmentArchive {
+	return &AttachmentArchive{dirs: make(map[string]*attachmentArchiveDir)}
+}
+
+// dir loads the index and calculates the disk usage of the archive dir once, must be called with the lock held
+func (aa *AttachmentArchive) dir(root string) (*attachmentArchiveDir, error) {
+	if d, ok := aa.dirs[root]; ok {
+		return d, nil
+	}
+
+	d := &attachmentArchiveDir{index: make(map[string]string)}
+	content, err := os.ReadFile(filepath.Join(root, archiveIndexFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &d.index); err != nil {
+			return nil, fmt.Errorf("attachment archive index: %w", err)
+		}
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			d.usage += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	aa.dirs[root] = d
+
+	return d, nil
+}
+
+// Save downloads the attachment into the rule's archive dir under its path pattern with the metadata sidecar.
+// Content already archived in the dir is not saved again, the sidecar of the repeated attachment is written
+// next to the earlier file (as <file>.<timestamp>.json) and refers to it.
+func (aa *AttachmentArchive) Save(conf *Config, rule *ConfigGroup, attachment SignalAttachments, meta AttachmentMeta) error {
+	root := rule.ArchiveDir
+	maxUsage := rule.ArchiveMaxMb * 1024 * 1024
+	if err := aa.checkUsage(root, maxUsage, 0); err != nil {
+		return err
+	}
+
+	//the download runs without the lock, the other attachments are saved meanwhile
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return err
+	}
+	tmp, size, sum, err := downloadToTemp(conf, root, attachment.Id)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) //no-op after rename
+
+	aa.mu.Lock()
+	defer aa.mu.Unlock()
+
+	d, err := aa.dir(root)
+	if err != nil {
+		return err
+	}
+
+	meta.Size = size
+	meta.Sha256 = sum
+	meta.ArchivedAt = time.Now().UTC()
+
+	var sidecarPath string
+	if existing, ok := d.index[meta.Sha256]; ok {
+		meta.DuplicateOf = existing
+		sidecarPath = filepath.Join(root, fmt.Sprintf("%s.%d.json", existing, meta.Timestamp))
+	} else {
+		if maxUsage > 0 && d.usage+size > maxUsage {
+			return ErrArchiveFull
+		}
+
+		relDir := archivePath(rule.ArchivePattern, meta)
+		if err := os.MkdirAll(filepath.Join(root, relDir), 0o750); err != nil {
+			return err
+		}
+		relPath := filepath.Join(relDir, meta.Sha256[:12]+"-"+sanitizePathPart(attachmentName(attachment)))
+		if err := os.Rename(tmp, filepath.Join(root, relPath)); err != nil {
+			return err
+		}
+		d.usage += size
+		d.index[meta.Sha256] = relPath
+		if err := aa.saveIndex(root, d); err != nil {
+			return err
+		}
+		sidecarPath = filepath.Join(root, relPath+".json")
+	}
+
+	sidecar, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(sidecarPath, sidecar, 0o640); err != nil {
+		return err
+	}
+	d.usage += int64(len(sidecar))
+
+	return nil
+}
+
+// checkUsage fails with ErrArchiveFull when the archive dir can't take size bytes more
+func (aa *AttachmentArchive) checkUsage(root string, maxUsage int64, size int64) error {
+	aa.mu.Lock()
+	defer aa.mu.Unlock()
+
+	d, err := aa.dir(root)
+	if err != nil {
+		return err
+	}
+	if maxUsage > 0 && d.usage+size >= maxUsage {
+		return ErrArchiveFull
+	}
+
+	return nil
+}
+
+// downloadToTemp saves the attachment into a temporary file in the dir, returning its path, size and sha256
+func downloadToTemp(conf *Config, dir string, id string) (string, int64, string, error) {
+	body, err := GetAttachment(conf, id)
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(dir, ".attachment-*")
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", 0, "", err
+	}
+
+	return tmp.Name(), size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (aa *AttachmentArchive) saveIndex(root string, d *attachmentArchiveDir) error {
+	content, err := json.Marshal(d.index)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(root, archiveIndexFile), content, 0o640)
+}
+
+// archivePath fills the pattern placeholders: {group}, {group_id}, {date}, {year}, {month}, {day} and {sender}
+func archivePath(pattern string, meta AttachmentMeta) string {
+	if len(pattern) == 0 {
+		pattern = defaultArchivePattern
+	}
+
+	t := time.UnixMilli(int64(meta.Timestamp))
+	group := meta.GroupName
+	if len(group) == 0 {
+		group = meta.GroupId
+	}
+	sender := meta.Sender
+	if len(sender) == 0 {
+		sender = "hidden"
+	}
+
+	replacer := strings.NewReplacer(
+		"{group}", sanitizePathPart(group),
+		"{group_id}", sanitizePathPart(meta.GroupId),
+		"{date}", t.Format("2006-01-02"),
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+		"{sender}", sanitizePathPart(sender),
+	)
+
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		parts[i] = replacer.Replace(part)
+	}
+
+	return filepath.Join(parts...)
+}
+
+// sanitizePathPart makes the value safe as a single file or directory name
+func sanitizePathPart(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	s = strings.Trim(s, ".")
+	if len(s) == 0 {
+		return "_"
+	}
+
+	return s
+}
+
+func attachmentName(a SignalAttachments) string {
+	if len(a.Filename) > 0 {
+		return a.Filename
+	}
+
+	return a.Id
+}
+
+// archiveAttachments saves the attachments of the envelope in the background, for the rules with archive_dir
+func (p *Processor) archiveAttachments(rule *ConfigGroup, env *SignalEnvelope) {
+	if len(rule.ArchiveDir) == 0 || len(env.DataMessage.Attachments) == 0 {
+		return
+	}
+
+	conf := p.Config()
+	meta := AttachmentMeta{
+		GroupId:    env.DataMessage.GroupInfo.GroupId,
+		GroupName:  p.directory.GroupName(env.DataMessage.GroupInfo.GroupId),
+		Sender:     p.AuthorName(rule, env),
+		SenderUuid: AuthorUuid(rule, env),
+		Timestamp:  env.Timestamp,
+		Message:    env.DataMessage.Message,
+	}
+	attachments := env.DataMessage.Attachments
+
+	go func() {
+		for _, a := range attachments {
+			m := meta
+			m.Caption, m.Filename, m.ContentType = a.Caption, a.Filename, a.ContentType
+
+			if err := p.attachments.Save(conf, rule, a, m); err != nil {
+				Rlog.Errorf("attachment %s archive error: %v", a.Id, err)
+				p.activity.Error(meta.GroupId, fmt.Errorf("attachment archive: %w", err))
+			}
+		}
+	}()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// CaptureRecord is a single line of the capture file
+	CaptureRecord struct {
+		Time     time.Time       `json:"time"`
+		Frame    json.RawMessage `json:"frame,omitempty"`
+		RawFrame string          `json:"raw_frame,omitempty"` //used when the frame is not a valid json
+		Decision string          `json:"decision"`
+		Error    string          `json:"error,omitempty"`
+	}
+
+	// Capture appends inbound websocket frames with routing decisions to a rotated JSONL file
+	Capture struct {
+		mu       sync.Mutex
+		conf     CaptureConfig
+		file     *os.File
+		size     int64
+		openedAt time.Time
+	}
+)
+
+func NewCapture(conf *CaptureConfig) (*Capture, error) {
+	if conf == nil || !conf.IsEnabled {
+		return nil, nil
+	}
+
+	c := &Capture{conf: *conf}
+	if err := os.MkdirAll(filepath.Dir(c.conf.Path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Record writes the frame and the routing decision taken for it; nil capture is a no-op
+func (c *Capture) Record(frame []byte, decision RoutingDecision, decisionErr error) {
+	if c == nil {
+		return
+	}
+
+	rec := CaptureRecord{
+		Time:     time.Now().UTC(),
+		Decision: c.redact(decision.String()),
+	}
+	if decisionErr != nil {
+		rec.Error = c.redact(decisionErr.Error())
+	}
+
+	cleaned, err := stripAttachmentContents(frame)
+	if err != nil {
+		rec.RawFrame = c.redact(string(frame))
+	} else {
+		rec.Frame = json.RawMessage(c.redact(string(cleaned)))
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		Rlog.Error("capture marshal error: ", err)
+		return
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.rotateIfNeeded(int64(len(line))); err != nil {
+		Rlog.Error("capture rotate error: ", err)
+		return
+	}
+
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	if err != nil {
+		Rlog.Error("capture write error: ", err)
+	}
+}
+
+func (c *Capture) Close() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+
+	return err
+}
+
+// redact masks the phone numbers when asked by the capture, or when they are not allowed at all
+func (c *Capture) redact(s string) string {
+	if !c.conf.RedactNumbers && Rlog.NumbersAllowed() {
+		return s
+	}
+
+	return RedactNumbers(s)
+}
+
+func (c *Capture) open() error {
+	f, err := os.OpenFile(c.conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	c.file = f
+	c.size = st.Size()
+	c.openedAt = time.Now()
+	if c.size > 0 {
+		c.openedAt = st.ModTime() //the file kept from before the restart ages from its last write
+	}
+
+	return nil
+}
+
+func (c *Capture) rotateIfNeeded(next int64) error {
+	if c.file == nil {
+		return errors.New("capture file is closed")
+	}
+
+	bySize := c.conf.MaxSizeMb > 0 && c.size > 0 && c.size+next > c.conf.MaxSizeMb*1024*1024
+	byAge := c.conf.MaxAgeHours > 0 && time.Since(c.openedAt) > time.Duration(c.conf.MaxAgeHours)*time.Hour
+	if !bySize && !byAge {
+		return nil
+	}
+
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+
+	rotated := fmt.Sprintf("%s.%s", c.conf.Path, time.Now().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(c.conf.Path, rotated); err != nil {
+		return err
+	}
+	Rlog.Debugf("capture file rotated to %s", rotated)
+
+	c.removeOldBackups()
+
+	return c.open()
+}
+
+func (c *Capture) removeOldBackups() {
+	if c.conf.MaxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(c.conf.Path + ".*")
+	if err != nil {
+		Rlog.Error("capture backups glob error: ", err)
+		return
+	}
+	sort.Strings(backups) //timestamp suffix sorts chronologically
+
+	for len(backups) > c.conf.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			Rlog.Error("capture backup remove error: ", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// stripAttachmentContents drops inline attachment payloads from the frame, leaving only their metadata
+func stripAttachmentContents(frame []byte) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(frame, &v); err != nil {
+		return nil, err
+	}
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch t := v.(type) {
+		case map[string]any:
+			for k, child := range t {
+				if k == "attachments" {
+					if list, ok := child.([]any); ok {
+						for _, a := range list {
+							if am, ok := a.(map[string]any); ok {
+								delete(am, "data")
+								delete(am, "base64")
+							}
+						}
+					}
+				}
+				walk(child)
+			}
+		case []any:
+			for _, child := range t {
+				walk(child)
+			}
+		}
+	}
+	walk(v)
+
+	return json.Marshal(v)
+}
+
+// ReadCapture reads capture records from the given JSONL file, calling fn for each of them
+func ReadCapture(filePath string, fn func(rec CaptureRecord) error) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ReplayCapture runs captured frames through the routing in dry run and prints the decisions
+func ReplayCapture(conf *Config, filePath string) error {
+	if conf == nil {
+		return errors.New("config is nil")
+	}
+
+	conf.Archive = nil //the archive is the bot's, replay only prints the decisions
+
+	processor, err := NewProcessor(conf)
+	if err != nil {
+		return err
+	}
+
+	return ReadCapture(filePath, func(rec CaptureRecord) error {
+		frame := []byte(rec.Frame)
+		if len(frame) == 0 {
+			frame = []byte(rec.RawFrame)
+		}
+
+		decision, _ := processor.HandleMessage(frame, rec.Time, true)
+		Rlog.Infof("%s captured: %q, replayed: %q", rec.Time.Format(time.RFC3339), rec.Decision, decision.String())
+
+		return nil
+	})
+}
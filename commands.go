@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type commandHandler func(p *Processor, args []string) (string, error)
+
+var commandHandlers = map[string]commandHandler{
+	"/help":    cmdHelp,
+	"/status":  cmdStatus,
+	"/rules":   cmdRules,
+	"/pause":   cmdPause,
+	"/resume":  cmdResume,
+	"/sending": cmdSending,
+	"/groups":  cmdGroups,
+}
+
+const commandsHelp = `/status -- bot status
+/rules -- forwarding rules
+/pause <rule> -- disable the rule (number from /rules, group id or group name)
+/resume <rule> -- enable the rule
+/sending on|off -- enable/disable forwarding
+/groups -- groups known to the bot`
+
+// isCommand tells whether the envelope is a command from an admin, in a direct message or in the admin group
+func isCommand(cc *CommandsConfig, env *SignalEnvelope) bool {
+	if cc == nil || !cc.IsEnabled || !strings.HasPrefix(env.DataMessage.Message, "/") {
+		return false
+	}
+
+	groupId := env.DataMessage.GroupInfo.GroupId
+	if len(groupId) > 0 && (len(cc.AdminGroupId) == 0 || !strings.EqualFold(groupId, cc.AdminGroupId)) {
+		return false
+	}
+
+	for _, uuid := range cc.AdminUUIDs {
+		if strings.EqualFold(uuid, env.SourceUuid) {
+			return true
+		}
+	}
+
+	Rlog.Infof("command %q from not admin %s ignored", env.DataMessage.Message, env.SourceUuid)
+
+	return false
+}
+
+// handleCommand runs the admin command and replies with its result where the command came from
+func (p *Processor) handleCommand(env *SignalEnvelope) error {
+	fields := strings.Fields(env.DataMessage.Message)
+	name := strings.ToLower(fields[0])
+
+	Rlog.Infof("command %q from admin %s", env.DataMessage.Message, env.SourceUuid)
+
+	var reply string
+	handler, ok := commandHandlers[name]
+	if !ok {
+		reply = fmt.Sprintf("unknown command %s\n%s", name, commandsHelp)
+	} else {
+		var err error
+		reply, err = handler(p, fields[1:])
+		if err != nil {
+			reply = fmt.Sprintf("%s error: %v", name, err)
+		}
+	}
+
+	recipient := env.SourceUuid
+	if len(recipient) == 0 {
+		recipient = env.Source
+	}
+	if groupId := env.DataMessage.GroupInfo.GroupId; len(groupId) > 0 {
+		recipient = GroupRecipient(groupId)
+	}
+
+	return SendReply(p.Config(), []string{recipient}, reply)
+}
+
+func cmdHelp(_ *Processor, _ []string) (string, error) {
+	return commandsHelp, nil
+}
+
+func cmdStatus(p *Processor, _ []string) (string, error) {
+	conf := p.Config()
+
+	enabled := 0
+	for _, rec := range conf.Forwarding {
+		if rec.IsEnabled {
+			enabled++
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("uptime: %s", time.Since(p.startedAt).Round(time.Second)),
+		fmt.Sprintf("sending: %s", onOff(conf.IsSendingEnabled)),
+		fmt.Sprintf("rules: %d enabled of %d", enabled, len(conf.Forwarding)),
+	}
+
+	pending := p.digests.Pending()
+	groupIds := make([]string, 0, len(pending))
+	for groupId := range pending {
+		groupIds = append(groupIds, groupId)
+	}
+	sort.Strings(groupIds)
+	for _, groupId := range groupIds {
+		lines = append(lines, fmt.Sprintf("digest pending for %s: %d", groupId, pending[groupId]))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func cmdRules(p *Processor, _ []string) (string, error) {
+	conf := p.Config()
+	if len(conf.Forwarding) == 0 {
+		return "no rules", nil
+	}
+
+	lines := make([]string, len(conf.Forwarding))
+	for i, rec := range conf.Forwarding {
+		receivers := append(append([]string{}, rec.ReceiversGroupIds...), rec.ReceiversGroupNames...)
+		lines[i] = fmt.Sprintf("%d. [%s] %s (%s) -> %s", i+1, onOff(rec.IsEnabled), rec.Ref(), rec.ForwardingMode, strings.Join(receivers, ", "))
+		if len(rec.Routes) > 0 {
+			lines[i] += fmt.Sprintf(" (+%d routes)", len(rec.Routes))
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func cmdPause(p *Processor, args []string) (string, error) {
+	return setRuleEnabled(p, args, false)
+}
+
+func cmdResume(p *Processor, args []string) (string, error) {
+	return setRuleEnabled(p, args, true)
+}
+
+func setRuleEnabled(p *Processor, args []string, isEnabled bool) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("rule number, group id or group name is required")
+	}
+
+	ref := strings.Join(args, " ") //group names may have spaces
+	var groupId string
+	_, err := p.UpdateConfig(func(c *Config) error {
+		i, err := c.FindRule(ref)
+		if err != nil {
+			return err
+		}
+		c.Forwarding[i].IsEnabled = isEnabled
+		groupId = c.Forwarding[i].Ref()
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("rule %s is %s", groupId, onOff(isEnabled)), nil
+}
+
+func cmdSending(p *Processor, args []string) (string, error) {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return "", fmt.Errorf("on or off is required")
+	}
+
+	isEnabled := args[0] == "on"
+	_, err := p.UpdateConfig(func(c *Config) error {
+		c.IsSendingEnabled = isEnabled
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sending is %s", onOff(isEnabled)), nil
+}
+
+func cmdGroups(p *Processor, _ []string) (string, error) {
+	groups, err := p.Groups()
+	if err != nil {
+		return "", err
+	}
+	if len(groups) == 0 {
+		return "no groups", nil
+	}
+
+	lines := make([]string, len(groups))
+	for i, group := range groups {
+		lines[i] = fmt.Sprintf("%s: %s", group.Name, group.InternalId)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func onOff(v bool) string {
+	if v {
+		return "on"
+	}
+
+	return "off"
+}
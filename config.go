@@ -1,43 +1,161 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type (
 	ForwardingMode string
 
 	ConfigGroup struct {
-		GroupId            string         `json:"group_id"`
-		IsEnabled          bool           `json:"is_enabled"`
-		ForwardingMode     ForwardingMode `json:"forwarding_mode"`
-		ReceiversGroupIds  []string       `json:"receivers_group_ids"`
-		BotSpecialAddonMsg string         `json:"bot_special_addon_msg,omitempty"`
-		ReactionMark       string         `json:"reaction_mark,omitempty"`
-		SenderNames        []string       `json:"sender_names,omitempty"`
-		SenderUUIDs        []string       `json:"sender_uuids,omitempty"`
-		StartsWith         []string       `json:"starts_with,omitempty"` //to filter messages, that starts with given patterns
-		Contains           []string       `json:"contains,omitempty"`    //to filter messages, that contains given patterns
+		GroupId             string            `json:"group_id"`
+		GroupName           string            `json:"group_name,omitempty"` //alternative to group_id, resolved by the group display name
+		IsEnabled           bool              `json:"is_enabled"`
+		ForwardingMode      ForwardingMode    `json:"forwarding_mode"`
+		ReceiversGroupIds   []string          `json:"receivers_group_ids"`
+		ReceiversGroupNames []string          `json:"receivers_group_names,omitempty"` //receivers given by the group display names
+		BotSpecialAddonMsg  string            `json:"bot_special_addon_msg,omitempty"`
+		ReactionMark        string            `json:"reaction_mark,omitempty"`
+		SenderNames         []string          `json:"sender_names,omitempty"`
+		SenderUUIDs         []string          `json:"sender_uuids,omitempty"`
+		StartsWith          []string          `json:"starts_with,omitempty"`           //to filter messages, that starts with given patterns
+		Contains            []string          `json:"contains,omitempty"`              //to filter messages, that contains given patterns
+		FilterAttachments   bool              `json:"filter_attachments,omitempty"`    //apply text filters to attachments body text and captions
+		ForwardOriginalText bool              `json:"forward_original_text,omitempty"` //forward body text/captions with attachments, not only addon msg
+		AlbumWindowMs       uint64            `json:"album_window_ms,omitempty"`       //time in ms to collect attachments of one sender into one message
+		AlbumMaxAttachments int               `json:"album_max_attachments,omitempty"` //max attachments per one batched message
+		Digest              *DigestConfig     `json:"digest,omitempty"`                //required in digest forwarding mode
+		AuthorDisplay       AuthorDisplay     `json:"author_display,omitempty"`        //full_name, first_name, pseudonym or hidden
+		Transforms          []TransformConfig `json:"transforms,omitempty"`            //applied in order to the forwarded text
+		Routes              []RouteConfig     `json:"routes,omitempty"`                //conditional receivers, the rule's receivers get the messages matching no route
+		ArchiveDir          string            `json:"archive_dir,omitempty"`           //save the attachments to this directory, deduplicated by content
+		ArchivePattern      string            `json:"archive_pattern,omitempty"`       //path under archive_dir, "{group}/{date}/{sender}" by default
+		ArchiveMaxMb        int64             `json:"archive_max_mb,omitempty"`        //stop saving when archive_dir is that large, 0 for no limit
+		AttachmentDedupSec  uint64            `json:"attachment_dedup_sec,omitempty"`  //don't forward the same attachment content to a receiver again within that time
+		AttachmentDedupMode DedupMode         `json:"attachment_dedup_mode,omitempty"` //skip (default) or annotate the repeated attachments
+		Images              *ImageConfig      `json:"images,omitempty"`                //processing of the forwarded images
+		Webhooks            []WebhookConfig   `json:"webhooks,omitempty"`              //receivers getting the forwarded messages as JSON POST requests
+		Email               *EmailConfig      `json:"email,omitempty"`                 //receivers getting the forwarded messages by email, see smtp
+
+		resolvedGroupId   string   //group id resolved from GroupName
+		resolvedReceivers []string //group ids resolved from ReceiversGroupNames
+	}
+	RouteConfig struct {
+		Name                string   `json:"name,omitempty"`
+		StartsWith          []string `json:"starts_with,omitempty"`     //text or captions start with any of
+		Contains            []string `json:"contains,omitempty"`        //text or captions contain any of
+		SenderUUIDs         []string `json:"sender_uuids,omitempty"`    //author is any of
+		HasAttachments      *bool    `json:"has_attachments,omitempty"` //message has (or has not) attachments
+		ContentTypes        []string `json:"content_types,omitempty"`   //any attachment's content type starts with any of, like "image/"
+		ReceiversGroupIds   []string `json:"receivers_group_ids,omitempty"`
+		ReceiversGroupNames []string `json:"receivers_group_names,omitempty"`
+		StopOnMatch         bool     `json:"stop_on_match,omitempty"` //don't check the next routes when this one matches
+
+		resolvedReceivers []string //group ids resolved from ReceiversGroupNames
+	}
+	ImageConfig struct {
+		MaxWidth      int    `json:"max_width,omitempty"`      //downscale keeping the aspect ratio, 0 for no limit
+		MaxHeight     int    `json:"max_height,omitempty"`     //downscale keeping the aspect ratio, 0 for no limit
+		JpegQuality   int    `json:"jpeg_quality,omitempty"`   //1-100, re-encode the images as JPEG with this quality
+		StripMetadata bool   `json:"strip_metadata,omitempty"` //re-encode the images to drop EXIF (GPS, camera) metadata
+		Watermark     string `json:"watermark,omitempty"`      //text drawn in the corner, {group} is the source group name
+	}
+	AlertsConfig struct {
+		IsEnabled           bool               `json:"is_enabled"`
+		Template            string             `json:"template,omitempty"` //Go text/template over the alert, a default one is used when empty
+		Routes              []AlertRouteConfig `json:"routes,omitempty"`
+		ReceiversGroupIds   []string           `json:"receivers_group_ids,omitempty"` //for the alerts matching no route
+		ReceiversGroupNames []string           `json:"receivers_group_names,omitempty"`
+
+		resolvedReceivers []string //group ids resolved from ReceiversGroupNames
+	}
+	AlertRouteConfig struct {
+		Matchers            []string `json:"matchers"` //all must match, like "severity=critical", "team=~db|infra", "env!=dev"
+		ReceiversGroupIds   []string `json:"receivers_group_ids,omitempty"`
+		ReceiversGroupNames []string `json:"receivers_group_names,omitempty"`
+		StopOnMatch         bool     `json:"stop_on_match,omitempty"` //don't check the next routes when this one matches
+
+		resolvedReceivers []string       //group ids resolved from ReceiversGroupNames
+		matchers          []LabelMatcher //parsed from Matchers by the config validation, so the regexes are compiled once
+	}
+	DigestConfig struct {
+		Schedule string `json:"schedule,omitempty"`  //cron-like "minute hour day month weekday", or @hourly, @daily, @weekly, @every 2h
+		MaxItems int    `json:"max_items,omitempty"` //send the digest when that many messages are collected, 0 to disable
+		Title    string `json:"title,omitempty"`
+	}
+	ApiConfig struct {
+		ListenAddress     string `json:"listen_address,omitempty"` //":8181" by default
+		TLSCertFile       string `json:"tls_cert_file,omitempty"`  //serve https when both cert and key are set
+		TLSKeyFile        string `json:"tls_key_file,omitempty"`
+		AuthToken         string `json:"auth_token,omitempty"` //bearer token for the private endpoints
+		BasicAuthUser     string `json:"basic_auth_user,omitempty"`
+		BasicAuthPassword string `json:"basic_auth_password,omitempty"`
+		SendRateLimit     int    `json:"send_rate_limit,omitempty"` //messages per minute accepted by POST /send, 30 by default
+		PublicURL         string `json:"public_url,omitempty"`      //the api address reachable by the webhooks, for the attachment links
+	}
+	CommandsConfig struct {
+		IsEnabled    bool     `json:"is_enabled"`
+		AdminUUIDs   []string `json:"admin_uuids"`              //only these senders can run commands
+		AdminGroupId string   `json:"admin_group_id,omitempty"` //commands are accepted in direct messages and in this group
+	}
+	ArchiveConfig struct {
+		IsEnabled     bool   `json:"is_enabled"`
+		Path          string `json:"path,omitempty"`           //archive.db next to the config file by default
+		RetentionDays int    `json:"retention_days,omitempty"` //entries older than that are purged, 0 to keep all
+	}
+	CaptureConfig struct {
+		IsEnabled     bool   `json:"is_enabled"`
+		Path          string `json:"path"`
+		MaxSizeMb     int64  `json:"max_size_mb,omitempty"`   //rotate when the file grows bigger, 0 to disable
+		MaxAgeHours   int64  `json:"max_age_hours,omitempty"` //rotate when the file is older, 0 to disable
+		MaxBackups    int    `json:"max_backups,omitempty"`   //rotated files to keep, 0 to keep all
+		RedactNumbers bool   `json:"redact_numbers"`
 	}
 	Config struct {
-		CLIAddress          string        `json:"cli_address"`
-		SelfNumber          string        `json:"self_number"`
-		IgnoreOlderMessages uint64        `json:"ignore_older_messages"`
-		IsSendingEnabled    bool          `json:"is_sending_enabled"`
-		IsPrintMessages     bool          `json:"is_print_messages"`
-		EnableDebugMessages bool          `json:"enable_debug_messages"`
-		Forwarding          []ConfigGroup `json:"forwarding"`
+		CLIAddress          string          `json:"cli_address"`
+		SelfNumber          string          `json:"self_number"`
+		IgnoreOlderMessages uint64          `json:"ignore_older_messages"`
+		IsSendingEnabled    bool            `json:"is_sending_enabled"`
+		IsPrintMessages     bool            `json:"is_print_messages"`
+		EnableDebugMessages bool            `json:"enable_debug_messages"`
+		Forwarding          []ConfigGroup   `json:"forwarding"`
+		Capture             *CaptureConfig  `json:"capture,omitempty"`
+		Archive             *ArchiveConfig  `json:"archive,omitempty"`
+		Alerts              *AlertsConfig   `json:"alerts,omitempty"`
+		Smtp                *SmtpConfig     `json:"smtp,omitempty"`              //mail server for the email receivers
+		Matrix              *MatrixConfig   `json:"matrix,omitempty"`            //bot account for the matrix: receivers
+		Telegram            *TelegramConfig `json:"telegram,omitempty"`          //bot for the telegram: receivers
+		DigestStorePath     string          `json:"digest_store_path,omitempty"` //where pending digest messages are kept, next to config by default
+		Commands            *CommandsConfig `json:"commands,omitempty"`
+		Api                 *ApiConfig      `json:"api,omitempty"`
+		DirectoryTTLSec     uint64          `json:"directory_ttl_sec,omitempty"` //how often the groups and contacts are refreshed, 300 by default
+		PseudonymSecret     string          `json:"pseudonym_secret,omitempty"`  //HMAC key of the authors pseudonyms
+		AllowNumbers        bool            `json:"allow_numbers,omitempty"`     //allow authors phone numbers in logs and forwarded content
+
+		path string //file the config is loaded from and saved to
 	}
 )
 
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+	ErrRuleNotFound  = errors.New("rule not found")
+)
+
 const (
 	FwModeAttachments ForwardingMode = "attachments"
 	FwModeMessages    ForwardingMode = "messages"
 	FwModeAll         ForwardingMode = "all"
+	FwModeDigest      ForwardingMode = "digest"
 )
 
 func (fm ForwardingMode) Validate() error {
@@ -47,6 +165,8 @@ func (fm ForwardingMode) Validate() error {
 	case "messages":
 		fallthrough
 	case "all":
+		fallthrough
+	case "digest":
 		return nil
 	default:
 		return fmt.Errorf("invalid forwarding mode: %s", fm)
@@ -67,9 +187,133 @@ func LoadConfig(filePath string) (c *Config, err error) {
 		return nil, err
 	}
 
+	c.path = filePath
+
 	return c, c.Validate()
 }
 
+// Save writes the config back to the file it was loaded from, atomically
+func (c *Config) Save() error {
+	if len(c.path) == 0 {
+		return fmt.Errorf("config file path is unknown")
+	}
+
+	var content bytes.Buffer
+	encoder := json.NewEncoder(&content)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path, content.Bytes(), 0o640)
+}
+
+// Clone returns a deep copy of the config, to be changed and swapped in without affecting its readers
+func (c *Config) Clone() (*Config, error) {
+	content, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone Config
+	if err := json.Unmarshal(content, &clone); err != nil {
+		return nil, err
+	}
+	clone.path = c.path
+
+	return &clone, nil
+}
+
+// FindRule returns the index of the forwarding rule given by its number (starting from 1), its group id or name
+func (c *Config) FindRule(ref string) (int, error) {
+	if len(ref) == 0 {
+		return 0, fmt.Errorf("%w: empty reference", ErrRuleNotFound)
+	}
+
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(c.Forwarding) {
+			return 0, fmt.Errorf("%w: number %d", ErrRuleNotFound, n)
+		}
+		return n - 1, nil
+	}
+
+	for i, rec := range c.Forwarding {
+		if strings.EqualFold(rec.GroupId, ref) || strings.EqualFold(rec.GroupName, ref) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: group %s", ErrRuleNotFound, ref)
+}
+
+// FindGroupRule returns the index of the rule forwarding the same source group as rec, matched by the group id
+// or name, or -1; unlike FindRule, a numeric group name is not taken for a rule number
+func (c *Config) FindGroupRule(rec *ConfigGroup) int {
+	for i := range c.Forwarding {
+		other := &c.Forwarding[i]
+		if len(rec.SourceGroupId()) > 0 && strings.EqualFold(other.SourceGroupId(), rec.SourceGroupId()) {
+			return i
+		}
+		if len(rec.GroupName) > 0 && strings.EqualFold(other.GroupName, rec.GroupName) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// UsesGroupNames tells whether any rule references groups by name, so the groups list is needed to route messages
+func (c *Config) UsesGroupNames() bool {
+	for _, rec := range c.Forwarding {
+		if len(rec.GroupName) > 0 || len(rec.ReceiversGroupNames) > 0 {
+			return true
+		}
+		for _, route := range rec.Routes {
+			if len(route.ReceiversGroupNames) > 0 {
+				return true
+			}
+		}
+	}
+
+	if c.Alerts != nil && c.Alerts.IsEnabled {
+		if len(c.Alerts.ReceiversGroupNames) > 0 {
+			return true
+		}
+		for _, route := range c.Alerts.Routes {
+			if len(route.ReceiversGroupNames) > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (c *Config) DirectoryTTL() time.Duration {
+	if c.DirectoryTTLSec == 0 {
+		return defaultDirectoryTTL
+	}
+
+	return time.Duration(c.DirectoryTTLSec) * time.Second
+}
+
+func (c *Config) ArchivePath() string {
+	if c.Archive != nil && len(c.Archive.Path) > 0 {
+		return c.Archive.Path
+	}
+
+	return filepath.Join(filepath.Dir(c.path), "archive.db")
+}
+
+func (c *Config) DigestStore() string {
+	if len(strings.TrimSpace(c.DigestStorePath)) > 0 {
+		return c.DigestStorePath
+	}
+
+	return filepath.Join(filepath.Dir(c.path), "digest.json")
+}
+
 func (c *Config) Validate() error {
 	c.CLIAddress = strings.TrimSpace(c.CLIAddress)
 	if len(c.CLIAddress) == 0 {
@@ -84,11 +328,48 @@ func (c *Config) Validate() error {
 	if len(c.Forwarding) > 0 {
 		for i, group := range c.Forwarding {
 			c.Forwarding[i].GroupId = strings.TrimSpace(group.GroupId)
-			if c.Forwarding[i].IsEnabled && len(c.Forwarding[i].GroupId) == 0 {
-				return fmt.Errorf("forwarding group id is required when record is enabled")
+			c.Forwarding[i].GroupName = strings.TrimSpace(group.GroupName)
+			if len(c.Forwarding[i].GroupId) > 0 && len(c.Forwarding[i].GroupName) > 0 {
+				return fmt.Errorf("forwarding group id and group name must not be used together")
+			}
+			if c.Forwarding[i].IsEnabled && len(c.Forwarding[i].Ref()) == 0 {
+				return fmt.Errorf("forwarding group id or group name is required when record is enabled")
+			}
+			for j := range c.Forwarding[i].ReceiversGroupNames {
+				c.Forwarding[i].ReceiversGroupNames[j] = strings.TrimSpace(c.Forwarding[i].ReceiversGroupNames[j])
+				if len(c.Forwarding[i].ReceiversGroupNames[j]) == 0 {
+					return fmt.Errorf("forwarding receivers group name must not be empty")
+				}
+			}
+			noReceivers := len(c.Forwarding[i].ReceiversGroupIds) == 0 && len(c.Forwarding[i].ReceiversGroupNames) == 0
+			if c.Forwarding[i].IsEnabled && noReceivers && len(c.Forwarding[i].Routes) == 0 && !c.Forwarding[i].HasExternalReceivers() {
+				return fmt.Errorf("forwarding at leat one receivers group id or name is required when record is enabled")
+			}
+			for j := range c.Forwarding[i].Webhooks {
+				if err := c.Forwarding[i].Webhooks[j].Validate(); err != nil {
+					return fmt.Errorf("forwarding webhook %d: %w", j+1, err)
+				}
+				if c.Forwarding[i].Webhooks[j].Attachments == WebhookAttachmentsURL && (c.Api == nil || len(strings.TrimSpace(c.Api.PublicURL)) == 0) {
+					return fmt.Errorf("forwarding webhook %d: attachments mode url requires api public url", j+1)
+				}
 			}
-			if c.Forwarding[i].IsEnabled && len(c.Forwarding[i].ReceiversGroupIds) == 0 {
-				return fmt.Errorf("forwarding at leat one receivers group id is required when record is enabled")
+			if err := c.Forwarding[i].Email.Validate(); err != nil {
+				return fmt.Errorf("forwarding %w", err)
+			}
+			if c.Forwarding[i].Email != nil && c.Smtp == nil {
+				return fmt.Errorf("forwarding email receivers require smtp settings")
+			}
+			normalizeDestinations(c.Forwarding[i].ReceiversGroupIds)
+			for j := range c.Forwarding[i].Routes {
+				normalizeDestinations(c.Forwarding[i].Routes[j].ReceiversGroupIds)
+				if err := c.Forwarding[i].Routes[j].Validate(); err != nil {
+					return fmt.Errorf("forwarding route %d: %w", j+1, err)
+				}
+				for _, id := range c.Forwarding[i].Routes[j].ReceiversGroupIds {
+					if err := c.validateDestination(id); err != nil {
+						return fmt.Errorf("forwarding route %d: %w", j+1, err)
+					}
+				}
 			}
 
 			if c.Forwarding[i].IsEnabled && len(c.Forwarding[i].ForwardingMode) == 0 {
@@ -98,6 +379,54 @@ func (c *Config) Validate() error {
 				return err
 			}
 
+			if c.Forwarding[i].IsEnabled && c.Forwarding[i].ForwardingMode == FwModeDigest {
+				if err := c.Forwarding[i].Digest.Validate(); err != nil {
+					return err
+				}
+				if len(c.Forwarding[i].Routes) > 0 {
+					return fmt.Errorf("forwarding routes are not supported in digest forwarding mode")
+				}
+			}
+
+			if err := c.Forwarding[i].AuthorDisplay.Validate(); err != nil {
+				return err
+			}
+			if c.Forwarding[i].AuthorDisplay == AuthorPseudonym && len(c.PseudonymSecret) == 0 {
+				return fmt.Errorf("pseudonym secret is required for pseudonym author display")
+			}
+
+			for j := range c.Forwarding[i].Transforms {
+				fn, err := c.Forwarding[i].Transforms[j].Compile(&c.Forwarding[i])
+				if err != nil {
+					return fmt.Errorf("forwarding transform %d: %w", j+1, err)
+				}
+				c.Forwarding[i].Transforms[j].compiled = fn
+			}
+
+			if err := c.Forwarding[i].Images.Validate(); err != nil {
+				return err
+			}
+			if err := c.Forwarding[i].AttachmentDedupMode.Validate(); err != nil {
+				return err
+			}
+
+			if c.Forwarding[i].ArchiveMaxMb < 0 {
+				return fmt.Errorf("forwarding archive max mb must not be negative")
+			}
+			if len(c.Forwarding[i].ArchivePattern) > 0 && len(c.Forwarding[i].ArchiveDir) == 0 {
+				return fmt.Errorf("forwarding archive pattern requires archive dir")
+			}
+			if strings.Contains(c.Forwarding[i].ArchivePattern, "..") || filepath.IsAbs(c.Forwarding[i].ArchivePattern) {
+				return fmt.Errorf("forwarding archive pattern must be a relative path inside archive dir")
+			}
+
+			if c.Forwarding[i].AlbumMaxAttachments < 0 {
+				return fmt.Errorf("forwarding album max attachments must not be negative")
+			}
+			if c.Forwarding[i].AlbumWindowMs > 0 && c.Forwarding[i].AlbumMaxAttachments == 0 {
+				c.Forwarding[i].AlbumMaxAttachments = DefaultAlbumMaxAttachments
+			}
+
 			if c.Forwarding[i].IsEnabled && len(c.Forwarding[i].ReceiversGroupIds) > 0 {
 				for j := range c.Forwarding[i].ReceiversGroupIds {
 					c.Forwarding[i].ReceiversGroupIds[j] = strings.TrimSpace(c.Forwarding[i].ReceiversGroupIds[j])
@@ -106,8 +435,325 @@ func (c *Config) Validate() error {
 					return fmt.Errorf("forwarding at leat one receivers group id is required not to be empty when record is enabled")
 				}
 			}
+			for _, id := range c.Forwarding[i].ReceiversGroupIds {
+				if err := c.validateDestination(id); err != nil {
+					return fmt.Errorf("forwarding %w", err)
+				}
+			}
+		}
+	}
+
+	if err := c.Capture.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Archive.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Alerts.Validate(); err != nil {
+		return err
+	}
+	if c.Alerts != nil {
+		normalizeDestinations(c.Alerts.ReceiversGroupIds)
+		for i := range c.Alerts.Routes {
+			normalizeDestinations(c.Alerts.Routes[i].ReceiversGroupIds)
+		}
+		for _, id := range c.Alerts.AllReceiversGroupIds() {
+			if err := c.validateDestination(id); err != nil {
+				return fmt.Errorf("alerts %w", err)
+			}
+		}
+	}
+
+	if err := c.Smtp.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Matrix.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Telegram.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Commands.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Api.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Ref returns the group id or the group name the rule is configured with
+func (cg *ConfigGroup) Ref() string {
+	if len(cg.GroupId) > 0 {
+		return cg.GroupId
+	}
+
+	return cg.GroupName
+}
+
+// SourceGroupId returns the id of the group the rule forwards from, given directly or resolved from the group name
+func (cg *ConfigGroup) SourceGroupId() string {
+	if len(cg.GroupId) > 0 {
+		return cg.GroupId
+	}
+
+	return cg.resolvedGroupId
+}
+
+// Receivers returns the receivers group ids, given directly and resolved from the group names
+func (cg *ConfigGroup) Receivers() []string {
+	if len(cg.resolvedReceivers) == 0 {
+		return cg.ReceiversGroupIds
+	}
+
+	receivers := make([]string, 0, len(cg.ReceiversGroupIds)+len(cg.resolvedReceivers))
+	receivers = append(receivers, cg.ReceiversGroupIds...)
+	return append(receivers, cg.resolvedReceivers...)
+}
+
+// AllReceivers returns the receivers of the rule and of all its routes, without duplicates
+func (cg *ConfigGroup) AllReceivers() []string {
+	receivers := append([]string{}, cg.Receivers()...)
+	for i := range cg.Routes {
+		receivers = appendUnique(receivers, cg.Routes[i].Receivers()...)
+	}
+
+	return receivers
+}
+
+func (rc *RouteConfig) Validate() error {
+	rc.Name = strings.TrimSpace(rc.Name)
+	for j := range rc.ReceiversGroupIds {
+		rc.ReceiversGroupIds[j] = strings.TrimSpace(rc.ReceiversGroupIds[j])
+		if len(rc.ReceiversGroupIds[j]) == 0 {
+			return fmt.Errorf("receivers group id must not be empty")
+		}
+	}
+	for j := range rc.ReceiversGroupNames {
+		rc.ReceiversGroupNames[j] = strings.TrimSpace(rc.ReceiversGroupNames[j])
+		if len(rc.ReceiversGroupNames[j]) == 0 {
+			return fmt.Errorf("receivers group name must not be empty")
+		}
+	}
+	if len(rc.ReceiversGroupIds) == 0 && len(rc.ReceiversGroupNames) == 0 {
+		return fmt.Errorf("at least one receivers group id or name is required")
+	}
+
+	return nil
+}
+
+// HasExternalReceivers tells whether the rule forwards outside Signal, like to webhooks or email
+func (cg *ConfigGroup) HasExternalReceivers() bool {
+	return len(cg.Webhooks) > 0 || cg.Email != nil
+}
+
+// Receivers returns the route's receivers group ids, given directly and resolved from the group names
+func (rc *RouteConfig) Receivers() []string {
+	return appendUnique(append([]string{}, rc.ReceiversGroupIds...), rc.resolvedReceivers...)
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		found := false
+		for _, existing := range list {
+			if existing == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func (dc *DigestConfig) Validate() error {
+	if dc == nil {
+		return fmt.Errorf("digest config is required in digest forwarding mode")
+	}
+
+	dc.Schedule = strings.TrimSpace(dc.Schedule)
+	if len(dc.Schedule) == 0 && dc.MaxItems <= 0 {
+		return fmt.Errorf("digest schedule or max items is required in digest forwarding mode")
+	}
+	if len(dc.Schedule) > 0 {
+		if _, err := ParseSchedule(dc.Schedule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ac *ApiConfig) Validate() error {
+	if ac == nil {
+		return nil
+	}
+
+	ac.ListenAddress = strings.TrimSpace(ac.ListenAddress)
+	ac.TLSCertFile = strings.TrimSpace(ac.TLSCertFile)
+	ac.TLSKeyFile = strings.TrimSpace(ac.TLSKeyFile)
+	if (len(ac.TLSCertFile) == 0) != (len(ac.TLSKeyFile) == 0) {
+		return fmt.Errorf("both api tls cert and key files are required for tls")
+	}
+	if (len(ac.BasicAuthUser) == 0) != (len(ac.BasicAuthPassword) == 0) {
+		return fmt.Errorf("both api basic auth user and password are required for basic auth")
+	}
+	if ac.SendRateLimit < 0 {
+		return fmt.Errorf("api send rate limit must not be negative")
+	}
+	ac.PublicURL = strings.TrimSpace(ac.PublicURL)
+	if len(ac.PublicURL) > 0 {
+		u, err := url.Parse(ac.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("api public url must be an http(s) url")
 		}
 	}
 
 	return nil
 }
+
+func (ac *ApiConfig) Addr() string {
+	if ac == nil || len(ac.ListenAddress) == 0 {
+		return ":8181"
+	}
+
+	return ac.ListenAddress
+}
+
+func (ac *ApiConfig) IsTLS() bool {
+	return ac != nil && len(ac.TLSCertFile) > 0
+}
+
+func (ac *ApiConfig) SendRate() int {
+	if ac == nil || ac.SendRateLimit == 0 {
+		return defaultSendRateLimit
+	}
+
+	return ac.SendRateLimit
+}
+
+// HasAuth tells whether any authentication for the private endpoints is configured
+func (ac *ApiConfig) HasAuth() bool {
+	return ac != nil && (len(ac.AuthToken) > 0 || len(ac.BasicAuthUser) > 0)
+}
+
+func (cc *CommandsConfig) Validate() error {
+	if cc == nil || !cc.IsEnabled {
+		return nil
+	}
+
+	cc.AdminGroupId = strings.TrimSpace(cc.AdminGroupId)
+	for i := range cc.AdminUUIDs {
+		cc.AdminUUIDs[i] = strings.TrimSpace(cc.AdminUUIDs[i])
+	}
+	if len(cc.AdminUUIDs) == 0 {
+		return fmt.Errorf("at least one admin uuid is required when commands are enabled")
+	}
+
+	return nil
+}
+
+func (ac *ArchiveConfig) Validate() error {
+	if ac == nil || !ac.IsEnabled {
+		return nil
+	}
+
+	ac.Path = strings.TrimSpace(ac.Path)
+	if ac.RetentionDays < 0 {
+		return fmt.Errorf("archive retention days must not be negative")
+	}
+
+	return nil
+}
+
+func (cc *CaptureConfig) Validate() error {
+	if cc == nil || !cc.IsEnabled {
+		return nil
+	}
+
+	cc.Path = strings.TrimSpace(cc.Path)
+	if len(cc.Path) == 0 {
+		return fmt.Errorf("capture path is required when capture is enabled")
+	}
+	if cc.MaxSizeMb < 0 || cc.MaxAgeHours < 0 || cc.MaxBackups < 0 {
+		return fmt.Errorf("capture rotation limits must not be negative")
+	}
+
+	return nil
+}
+
+func (ic *ImageConfig) Validate() error {
+	if ic == nil {
+		return nil
+	}
+
+	if ic.MaxWidth < 0 || ic.MaxHeight < 0 {
+		return fmt.Errorf("image max width and height must not be negative")
+	}
+	if ic.JpegQuality < 0 || ic.JpegQuality > 100 {
+		return fmt.Errorf("image jpeg quality must be between 1 and 100")
+	}
+
+	return nil
+}
+
+func (ac *AlertsConfig) Validate() error {
+	if ac == nil || !ac.IsEnabled {
+		return nil
+	}
+
+	if _, err := ac.compileTemplate(); err != nil {
+		return fmt.Errorf("alerts template: %w", err)
+	}
+	for i := range ac.Routes {
+		if len(ac.Routes[i].Matchers) == 0 {
+			return fmt.Errorf("alerts route %d: at least one matcher is required", i+1)
+		}
+		matchers := make([]LabelMatcher, len(ac.Routes[i].Matchers))
+		for j, s := range ac.Routes[i].Matchers {
+			var err error
+			if matchers[j], err = ParseLabelMatcher(s); err != nil {
+				return fmt.Errorf("alerts route %d: %w", i+1, err)
+			}
+		}
+		ac.Routes[i].matchers = matchers
+		if len(ac.Routes[i].ReceiversGroupIds) == 0 && len(ac.Routes[i].ReceiversGroupNames) == 0 {
+			return fmt.Errorf("alerts route %d: at least one receivers group id or name is required", i+1)
+		}
+	}
+	if len(ac.Routes) == 0 && len(ac.ReceiversGroupIds) == 0 && len(ac.ReceiversGroupNames) == 0 {
+		return fmt.Errorf("alerts require routes or receivers")
+	}
+
+	return nil
+}
+
+// AllReceiversGroupIds returns the group ids given directly, of the routes and the default ones
+func (ac *AlertsConfig) AllReceiversGroupIds() []string {
+	ids := append([]string{}, ac.ReceiversGroupIds...)
+	for i := range ac.Routes {
+		ids = append(ids, ac.Routes[i].ReceiversGroupIds...)
+	}
+
+	return ids
+}
+
+// Receivers returns the default receivers group ids, given directly and resolved from the group names
+func (ac *AlertsConfig) Receivers() []string {
+	return appendUnique(append([]string{}, ac.ReceiversGroupIds...), ac.resolvedReceivers...)
+}
+
+// Receivers returns the route's receivers group ids, given directly and resolved from the group names
+func (ar *AlertRouteConfig) Receivers() []string {
+	return appendUnique(append([]string{}, ar.ReceiversGroupIds...), ar.resolvedReceivers...)
+}
@@ -0,0 +1,195 @@
+(function () {
+  'use strict';
+
+  let token = sessionStorage.getItem('token') || '';
+  let groups = [];
+
+  // api calls the bot's endpoints; basic auth is handled by the browser, a bearer token is asked once
+  async function api(path, options) {
+    options = options || {};
+    options.headers = Object.assign({'Content-Type': 'application/json'}, options.headers);
+    if (token) {
+      options.headers['Authorization'] = 'Bearer ' + token;
+    }
+
+    const resp = await fetch(path, options);
+    if (resp.status === 401 && !resp.headers.get('WWW-Authenticate')) {
+      token = prompt('API token') || '';
+      sessionStorage.setItem('token', token);
+      if (!token) {
+        throw new Error('unauthorized');
+      }
+      return api(path, options);
+    }
+
+    const body = resp.status === 204 ? null : await resp.json().catch(() => null);
+    if (!resp.ok) {
+      throw new Error((body && body.error) || resp.statusText);
+    }
+    return body;
+  }
+
+  function el(tag, attrs, text) {
+    const e = document.createElement(tag);
+    Object.entries(attrs || {}).forEach(([k, v]) => e.setAttribute(k, v));
+    if (text !== undefined) {
+      e.textContent = text;
+    }
+    return e;
+  }
+
+  function groupName(id) {
+    const g = groups.find(g => g.internal_id === id || g.id === id);
+    return g ? g.name : id;
+  }
+
+  // rules may reference groups by name, the ids resolved by the bot are preferred
+  function ruleSource(r) {
+    return r.source_group_id || r.group_id || r.group_name;
+  }
+
+  function ruleReceivers(r) {
+    return r.receivers || r.receivers_group_ids || [];
+  }
+
+  function fillTable(id, rows) {
+    const tbody = document.querySelector('#' + id + ' tbody');
+    tbody.replaceChildren(...rows.map(cells => {
+      const tr = el('tr');
+      cells.forEach(c => tr.appendChild(typeof c === 'string' ? el('td', {}, c) : c));
+      return tr;
+    }));
+  }
+
+  function time(t) {
+    return t ? new Date(t).toLocaleString() : '';
+  }
+
+  function renderStatus(s) {
+    const status = document.getElementById('status');
+    status.replaceChildren(
+      el('span', {class: s.activity.is_connected ? 'on' : 'off'}, s.activity.is_connected ? 'connected' : 'disconnected'),
+      el('span', {class: s.is_sending_enabled ? 'on' : 'off'}, 'sending ' + (s.is_sending_enabled ? 'on' : 'off')),
+      el('span', {}, 'last message: ' + (time(s.activity.last_received) || 'none')),
+    );
+    if (s.unavailable_receivers.length) {
+      const text = s.unavailable_receivers.length + ' unavailable receivers';
+      const details = s.unavailable_receivers.map(r => r.name + ': ' + r.problem).join('\n');
+      status.appendChild(el('span', {class: 'off', title: details}, text));
+    }
+  }
+
+  function renderRules(rules) {
+    fillTable('rules', rules.map(r => [
+      String(r.number),
+      r.is_enabled ? 'on' : 'off',
+      groupName(ruleSource(r)),
+      r.forwarding_mode,
+      ruleReceivers(r).map(groupName).join(', '),
+    ]));
+    renderGraph(rules);
+  }
+
+  // renderGraph draws sources on the left and receivers on the right, connected by the rules
+  function renderGraph(rules) {
+    const svg = document.getElementById('graph');
+    const ns = 'http://www.w3.org/2000/svg';
+    const sources = [...new Set(rules.map(ruleSource))];
+    const receivers = [...new Set(rules.flatMap(ruleReceivers))];
+    const rowHeight = 28;
+    const height = Math.max(sources.length, receivers.length, 1) * rowHeight + 10;
+    const width = svg.clientWidth || 800;
+    const y = (list, id) => list.indexOf(id) * rowHeight + 20;
+
+    svg.setAttribute('height', height);
+    svg.replaceChildren();
+
+    const add = (tag, attrs, text) => {
+      const e = document.createElementNS(ns, tag);
+      Object.entries(attrs).forEach(([k, v]) => e.setAttribute(k, v));
+      if (text !== undefined) {
+        e.textContent = text;
+      }
+      svg.appendChild(e);
+    };
+
+    rules.forEach(r => ruleReceivers(r).forEach(rcv => add('line', {
+      x1: width * .3, y1: y(sources, ruleSource(r)) - 4,
+      x2: width * .7, y2: y(receivers, rcv) - 4,
+      class: r.is_enabled ? '' : 'disabled',
+    })));
+    sources.forEach(id => add('text', {x: width * .3 - 6, y: y(sources, id), 'text-anchor': 'end'}, groupName(id)));
+    receivers.forEach(id => add('text', {x: width * .7 + 6, y: y(receivers, id)}, groupName(id)));
+  }
+
+  function renderGroups() {
+    fillTable('groups', groups.map(g => {
+      const members = el('td');
+      const details = el('details');
+      details.appendChild(el('summary', {}, String((g.members || []).length)));
+      details.appendChild(el('div', {}, (g.members || []).join(', ')));
+      members.appendChild(details);
+      return [g.name, el('td', {class: 'id'}, g.internal_id), members];
+    }));
+
+    const form = document.getElementById('rule-form');
+    [form.group_id, form.receivers_group_ids].forEach(select => {
+      const selected = [...select.selectedOptions].map(o => o.value);
+      select.replaceChildren(...groups.map(g => {
+        const o = el('option', {value: g.internal_id}, g.name);
+        o.selected = selected.includes(g.internal_id);
+        return o;
+      }));
+    });
+  }
+
+  function renderActivity(a) {
+    fillTable('recent', a.recent.map(e => [time(e.time), groupName(e.group_id), e.sender || '', e.text || '', e.decision]));
+    fillTable('errors', a.errors.map(e => [time(e.time), groupName(e.group_id || ''), e.error]));
+  }
+
+  async function refresh() {
+    try {
+      const s = await api('/status');
+      renderStatus(s);
+      renderRules(s.rules);
+      renderActivity(s.activity);
+    } catch (e) {
+      document.getElementById('status').textContent = 'status error: ' + e.message;
+    }
+  }
+
+  async function loadGroups() {
+    try {
+      groups = await api('/groups');
+      renderGroups();
+    } catch (e) {
+      fillTable('groups', [['groups error: ' + e.message, '', '']]);
+    }
+  }
+
+  document.getElementById('rule-form').addEventListener('submit', async ev => {
+    ev.preventDefault();
+    const form = ev.target;
+    const result = document.getElementById('rule-form-result');
+    const rule = {
+      group_id: form.group_id.value,
+      is_enabled: form.is_enabled.checked,
+      forwarding_mode: form.forwarding_mode.value,
+      receivers_group_ids: [...form.receivers_group_ids.selectedOptions].map(o => o.value),
+    };
+
+    try {
+      const created = await api('/admin/rules', {method: 'POST', body: JSON.stringify(rule)});
+      result.className = 'form-result';
+      result.textContent = 'rule ' + created.number + ' created';
+      refresh();
+    } catch (e) {
+      result.className = 'form-result error';
+      result.textContent = e.message;
+    }
+  });
+
+  loadGroups().then(refresh);
+  setInterval(refresh, 5000);
+})();
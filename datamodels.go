@@ -69,4 +69,13 @@ type (
 		PendingInvites  []string `json:"pending_invites"`
 		PendingRequests []string `json:"pending_requests"`
 	}
+
+	SignalContact struct {
+		Number      string `json:"number"`
+		Uuid        string `json:"uuid"`
+		Name        string `json:"name"`
+		ProfileName string `json:"profile_name"`
+		Username    string `json:"username"`
+		Blocked     bool   `json:"blocked"`
+	}
 )
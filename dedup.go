@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	DedupMode string
+
+	// AttachmentDedup remembers when the attachment content was forwarded to every receiver
+	AttachmentDedup struct {
+		mu        sync.Mutex
+		sent      map[string]time.Time //receiver|sha256 -> forwarded at
+		maxWindow time.Duration        //entries older than the longest window are dropped
+	}
+)
+
+const (
+	DedupSkip     DedupMode = "skip"     //the repeated attachment is not forwarded (default)
+	DedupAnnotate DedupMode = "annotate" //the repeated attachment is replaced by a note in the text
+)
+
+func (m DedupMode) Validate() error {
+	switch m {
+	case "", DedupSkip, DedupAnnotate:
+		return nil
+	default:
+		return fmt.Errorf("invalid attachment dedup mode: %s", m)
+	}
+}
+
+func NewAttachmentDedup() *AttachmentDedup {
+	return &AttachmentDedup{sent: make(map[string]time.Time)}
+}
+
+func dedupKey(receiver, sum string) string {
+	return receiver + "|" + sum
+}
+
+// Claim checks the contents against the ones forwarded to the receiver within the window and records
+// the new ones as forwarded at once, so the concurrent messages don't both take the same content as new;
+// it returns which of the sums are new
+func (d *AttachmentDedup) Claim(receiver string, sums []string, window time.Duration, now time.Time) []bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.maxWindow = max(d.maxWindow, window)
+	for key, sentAt := range d.sent {
+		if now.Sub(sentAt) >= d.maxWindow {
+			delete(d.sent, key)
+		}
+	}
+
+	isNew := make([]bool, len(sums))
+	for i, sum := range sums {
+		key := dedupKey(receiver, sum)
+		if sentAt, ok := d.sent[key]; ok && now.Sub(sentAt) < window {
+			continue
+		}
+		d.sent[key] = now
+		isNew[i] = true
+	}
+
+	return isNew
+}
+
+// Release drops the claims of the contents that failed to be forwarded, so they are sent next time
+func (d *AttachmentDedup) Release(receiver string, sums []string, claimedAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, sum := range sums {
+		key := dedupKey(receiver, sum)
+		if sentAt, ok := d.sent[key]; ok && sentAt.Equal(claimedAt) {
+			delete(d.sent, key)
+		}
+	}
+}
+
+// sendDeduplicated sends every receiver only the attachments it hasn't got within the rule's dedup window;
+// in the skip mode nothing is sent to the receiver when all the attachments are repeated. It returns how many
+// receivers got the message.
+func (p *Processor) sendDeduplicated(conf *Config, rule *ConfigGroup, receivers []string, downloaded []DownloadedAttachment, text string) (int, error) {
+	window := time.Duration(rule.AttachmentDedupSec) * time.Second
+	now := time.Now()
+
+	sums := make([]string, len(downloaded))
+	for i, a := range downloaded {
+		sums[i] = a.Sha256
+	}
+
+	sent := 0
+	var errs []error
+	for _, receiver := range receivers {
+		isNew := p.dedup.Claim(receiver, sums, window, now)
+
+		kept := make([]DownloadedAttachment, 0, len(downloaded))
+		var claimed, repeated []string
+		for i, a := range downloaded {
+			if isNew[i] {
+				kept = append(kept, a)
+				claimed = append(claimed, a.Sha256)
+			} else {
+				repeated = append(repeated, attachmentName(a.SignalAttachments))
+			}
+		}
+
+		receiverText := text
+		if len(repeated) > 0 {
+			Rlog.Infof("attachments %s were already forwarded to %s", strings.Join(repeated, ","), receiver)
+			if rule.AttachmentDedupMode == DedupAnnotate {
+				receiverText = Footer("♻ already forwarded: " + strings.Join(repeated, ", "))(receiverText)
+			} else if len(kept) == 0 {
+				continue
+			}
+		}
+
+		n, err := p.deliver(conf, rule.SourceGroupId(), []string{receiver}, receiverText, kept)
+		if err != nil {
+			p.dedup.Release(receiver, claimed, now)
+			errs = append(errs, err)
+		}
+		sent += n
+	}
+
+	return sent, errors.Join(errs...)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachmentDedupClaim(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	window := time.Minute
+
+	tests := []struct {
+		name     string
+		receiver string
+		sums     []string
+		after    time.Duration
+		want     []bool
+	}{
+		{name: "first time", receiver: "r1", sums: []string{"a", "b"}, want: []bool{true, true}},
+		{name: "repeated within the window", receiver: "r1", sums: []string{"a", "c"}, after: 30 * time.Second, want: []bool{false, true}},
+		{name: "same content in one message", receiver: "r1", sums: []string{"d", "d"}, after: 30 * time.Second, want: []bool{true, false}},
+		{name: "other receiver", receiver: "r2", sums: []string{"a"}, after: 30 * time.Second, want: []bool{true}},
+		{name: "window expired", receiver: "r1", sums: []string{"a", "b"}, after: time.Minute, want: []bool{true, true}},
+	}
+
+	d := NewAttachmentDedup()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := d.Claim(tt.receiver, tt.sums, window, start.Add(tt.after))
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("new = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	d.Claim("r3", []string{"e"}, window, start.Add(3*time.Minute))
+	if n := len(d.sent); n != 1 {
+		t.Errorf("entries = %d, want the expired ones dropped", n)
+	}
+}
+
+func TestAttachmentDedupRelease(t *testing.T) {
+	d := NewAttachmentDedup()
+	first := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second)
+
+	d.Claim("r", []string{"a"}, time.Minute, first)
+	d.Release("r", []string{"a"}, second) //someone else's claim is kept
+	if got := d.Claim("r", []string{"a"}, time.Minute, second); got[0] {
+		t.Fatal("the claim of another time is released")
+	}
+
+	d.Release("r", []string{"a"}, first)
+	if got := d.Claim("r", []string{"a"}, time.Minute, second); !got[0] {
+		t.Error("the failed content is not sent again")
+	}
+}
+
+func TestSendDeduplicated(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 200, map[string]any{"ok": true, "result": map[string]any{"message_id": 1}}
+	})
+	conf := &Config{IsSendingEnabled: true, Telegram: &TelegramConfig{BotToken: "1:a", APIURL: srv.URL}}
+	photo := DownloadedAttachment{SignalAttachments: SignalAttachments{ContentType: "image/jpeg", Filename: "a.jpg"}, Content: []byte("a"), Sha256: "sum-a"}
+
+	tests := []struct {
+		name  string
+		mode  DedupMode
+		calls []string //the Bot API methods of the repeated message
+		text  string
+	}{
+		{name: "skip", mode: DedupSkip},
+		{name: "annotate", mode: DedupAnnotate, calls: []string{"sendMessage"}, text: "look\n♻ already forwarded: a.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Processor{activity: new(Activity), dedup: NewAttachmentDedup()}
+			rule := &ConfigGroup{GroupId: "source", AttachmentDedupSec: 60, AttachmentDedupMode: tt.mode}
+			receivers := []string{"telegram:1"}
+
+			if sent, err := p.sendDeduplicated(conf, rule, receivers, []DownloadedAttachment{photo}, "look"); err != nil || sent != 1 {
+				t.Fatalf("first send = %d, %v", sent, err)
+			}
+			before := len(srv.recorded())
+
+			sent, err := p.sendDeduplicated(conf, rule, receivers, []DownloadedAttachment{photo}, "look")
+			if err != nil || sent != len(tt.calls) {
+				t.Fatalf("repeated send = %d, %v", sent, err)
+			}
+
+			calls := srv.recorded()[before:]
+			var methods []string
+			for _, call := range calls {
+				methods = append(methods, call.Path[strings.LastIndex(call.Path, "/")+1:])
+			}
+			if strings.Join(methods, ",") != strings.Join(tt.calls, ",") {
+				t.Fatalf("calls = %v, want %v", methods, tt.calls)
+			}
+			if len(calls) > 0 && jsonBody(t, calls[0])["text"] != tt.text {
+				t.Errorf("text = %v, want %q", jsonBody(t, calls[0])["text"], tt.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// DigestItem is a pending message; the author is kept as the rule displays it, no phone numbers are stored
+	DigestItem struct {
+		Timestamp   uint64              `json:"timestamp"`
+		SourceName  string              `json:"source_name,omitempty"` //author as displayed by the rule
+		SourceUuid  string              `json:"source_uuid,omitempty"` //for the read receipt and the reaction
+		Text        string              `json:"text,omitempty"`
+		Attachments []SignalAttachments `json:"attachments,omitempty"`
+	}
+
+	digestTimer struct {
+		schedule *Schedule
+		timer    *time.Timer
+	}
+
+	// Digester buffers messages of the digest records and sends them combined on schedule or count threshold.
+	// The buffer is persisted to the store file on every change, so pending items survive restarts.
+	Digester struct {
+		mu      sync.Mutex
+		path    string
+		items   map[string][]DigestItem //by record group id
+		timers  map[string]*digestTimer
+		flushFn func(groupId string, items []DigestItem) error
+	}
+)
+
+func NewDigester(path string, flushFn func(groupId string, items []DigestItem) error) (*Digester, error) {
+	d := &Digester{
+		path:    path,
+		items:   make(map[string][]DigestItem),
+		timers:  make(map[string]*digestTimer),
+		flushFn: flushFn,
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &d.items); err != nil {
+			return nil, fmt.Errorf("digest store %s decode error: %w", path, err)
+		}
+	}
+
+	return d, nil
+}
+
+// Start (re)schedules the digests of the enabled digest records
+func (d *Digester) Start(records []ConfigGroup) {
+	d.Stop()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, rec := range records {
+		if !rec.IsEnabled || rec.ForwardingMode != FwModeDigest || rec.Digest == nil || len(rec.Digest.Schedule) == 0 {
+			continue
+		}
+
+		schedule, err := ParseSchedule(rec.Digest.Schedule)
+		if err != nil {
+			Rlog.Errorf("digest schedule of group %s error: %v", rec.Ref(), err)
+			continue
+		}
+
+		dt := &digestTimer{schedule: schedule}
+		d.timers[rec.SourceGroupId()] = dt
+		d.arm(rec.SourceGroupId(), dt)
+	}
+}
+
+func (d *Digester) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for groupId, dt := range d.timers {
+		dt.timer.Stop()
+		delete(d.timers, groupId)
+	}
+}
+
+// arm sets the timer to the next scheduled time, must be called with the lock held
+func (d *Digester) arm(groupId string, dt *digestTimer) {
+	next := dt.schedule.Next(time.Now())
+	if next.IsZero() {
+		Rlog.Errorf("digest schedule of group %s never fires", groupId)
+		return
+	}
+	Rlog.Debugf("next digest of group %s at %s", groupId, next.Format(time.RFC3339))
+
+	dt.timer = time.AfterFunc(time.Until(next), func() {
+		d.mu.Lock()
+		isCurrent := d.timers[groupId] == dt
+		d.mu.Unlock()
+		if !isCurrent {
+			return
+		}
+
+		d.Flush(groupId)
+
+		d.mu.Lock()
+		if d.timers[groupId] == dt {
+			d.arm(groupId, dt)
+		}
+		d.mu.Unlock()
+	})
+}
+
+// Add buffers the item and returns the number of items pending for the record
+func (d *Digester) Add(groupId string, item DigestItem) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.items[groupId] = append(d.items[groupId], item)
+
+	return len(d.items[groupId]), d.save()
+}
+
+// Flush sends the pending items of the record; on failure they are kept for the next time
+func (d *Digester) Flush(groupId string) {
+	d.mu.Lock()
+	items := d.items[groupId]
+	delete(d.items, groupId)
+	d.mu.Unlock()
+
+	if len(items) == 0 {
+		return
+	}
+
+	err := d.flushFn(groupId, items)
+	if err != nil {
+		Rlog.Errorf("digest of group %s send error: %v", groupId, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		d.items[groupId] = append(items, d.items[groupId]...)
+	}
+	if err := d.save(); err != nil {
+		Rlog.Error("digest store save error: ", err)
+	}
+}
+
+// Pending returns the number of buffered items per record
+func (d *Digester) Pending() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending := make(map[string]int, len(d.items))
+	for groupId, items := range d.items {
+		pending[groupId] = len(items)
+	}
+
+	return pending
+}
+
+// save writes the buffer to the store file atomically, must be called with the lock held
+func (d *Digester) save() error {
+	if len(d.path) == 0 {
+		return nil
+	}
+
+	content, err := json.Marshal(d.items)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(d.path, content, 0o640)
+}
+
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// FormatDigest renders the items grouped by sender, in the order senders appeared
+func FormatDigest(title string, items []DigestItem) string {
+	if len(title) == 0 {
+		title = "Digest"
+	}
+
+	var (
+		order    []string
+		bySender = make(map[string][]DigestItem)
+	)
+	for _, item := range items {
+		key := "" //authors hidden by the rule are listed together, without the name
+		if len(item.SourceName) > 0 {
+			key = item.SourceUuid + "/" + item.SourceName
+		}
+		if _, ok := bySender[key]; !ok {
+			order = append(order, key)
+		}
+		bySender[key] = append(bySender[key], item)
+	}
+
+	var b strings.Builder
+	b.WriteString(title)
+	for _, key := range order {
+		senderItems := bySender[key]
+		b.WriteString("\n")
+		if name := senderItems[0].SourceName; len(name) > 0 {
+			b.WriteString("\n")
+			b.WriteString(name)
+			b.WriteString(":")
+		}
+
+		for _, item := range senderItems {
+			at := time.UnixMilli(int64(item.Timestamp)).Format("02.01 15:04")
+			b.WriteString(fmt.Sprintf("\n[%s] %s", at, item.Text))
+			if len(item.Attachments) > 0 {
+				if len(item.Text) > 0 {
+					b.WriteString(" ")
+				}
+				b.WriteString(fmt.Sprintf("(attachments: %d)", len(item.Attachments)))
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// dispatchDigest sends the digest of the record with the collected attachments
+func (p *Processor) dispatchDigest(groupId string, items []DigestItem) error {
+	conf := p.Config()
+	if !conf.IsSendingEnabled {
+		return errors.New("sending messages disabled")
+	}
+
+	rec, err := GetForwardingRecord(conf, groupId)
+	if err != nil {
+		return err
+	}
+	if rec == nil || rec.ForwardingMode != FwModeDigest {
+		return fmt.Errorf("digest record for group %s is not found or disabled", groupId)
+	}
+
+	var (
+		attachments []SignalAttachments
+		envs        = make([]*SignalEnvelope, len(items))
+	)
+	for i, item := range items {
+		attachments = append(attachments, item.Attachments...)
+		envs[i] = &SignalEnvelope{Source: item.SourceUuid, SourceUuid: item.SourceUuid, Timestamp: item.Timestamp}
+	}
+
+	Rlog.Debugf("sending digest of %d messages from group %s", len(items), groupId)
+
+	return p.dispatchChunked(rec, rec.Receivers(), FormatDigest(rec.Digest.Title, items), attachments, envs)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDirectoryTTL = 5 * time.Minute
+
+var ErrGroupNotFound = errors.New("group not found")
+
+// Directory caches the groups and contacts of the bot's Signal account, for the group names resolution,
+// membership checks, templates and logging. It is refreshed in the background every TTL.
+type Directory struct {
+	mu        sync.RWMutex
+	groups    []SignalGroupEntry
+	contacts  map[string]SignalContact //by uuid
+	knownIds  map[string]string        //lowercase name -> internal id, including former names of renamed groups
+	updatedAt time.Time
+}
+
+func NewDirectory() *Directory {
+	return &Directory{contacts: make(map[string]SignalContact), knownIds: make(map[string]string)}
+}
+
+// Refresh fetches the groups and contacts lists, logging the groups renamed since the previous refresh.
+// Contacts are informational, so the previous ones are kept when they can't be fetched.
+func (d *Directory) Refresh(conf *Config) error {
+	groups, err := GetGroupsList(conf)
+	if err != nil {
+		return err
+	}
+
+	contacts, err := GetContactsList(conf)
+	if err != nil {
+		Rlog.Error("contacts refresh error: ", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	previous := make(map[string]string, len(d.groups))
+	for _, g := range d.groups {
+		previous[g.InternalId] = g.Name
+	}
+	for _, g := range groups {
+		if name, ok := previous[g.InternalId]; ok && name != g.Name {
+			Rlog.Infof("group %s is renamed from %q to %q", g.InternalId, name, g.Name)
+		}
+		d.knownIds[strings.ToLower(g.Name)] = g.InternalId
+	}
+	d.groups = groups
+
+	if contacts != nil {
+		d.contacts = make(map[string]SignalContact, len(contacts))
+		for _, c := range contacts {
+			if len(c.Uuid) > 0 {
+				d.contacts[c.Uuid] = c
+			}
+		}
+	}
+
+	d.updatedAt = time.Now()
+	Rlog.Debugf("directory refreshed: %d groups, %d contacts", len(d.groups), len(d.contacts))
+
+	return nil
+}
+
+// IsStale tells whether the directory was never loaded or is older than ttl
+func (d *Directory) IsStale(ttl time.Duration) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.updatedAt.IsZero() || time.Since(d.updatedAt) > ttl
+}
+
+func (d *Directory) UpdatedAt() time.Time {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.updatedAt
+}
+
+func (d *Directory) Groups() []SignalGroupEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.groups
+}
+
+func (d *Directory) Contacts() []SignalContact {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	contacts := make([]SignalContact, 0, len(d.contacts))
+	for _, c := range d.contacts {
+		contacts = append(contacts, c)
+	}
+
+	return contacts
+}
+
+// Group finds the group by its internal id or its "group." id
+func (d *Directory) Group(id string) (SignalGroupEntry, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, g := range d.groups {
+		if g.InternalId == id || g.Id == id {
+			return g, true
+		}
+	}
+
+	return SignalGroupEntry{}, false
+}
+
+// GroupName returns the group display name, or the id for unknown groups
+func (d *Directory) GroupName(id string) string {
+	if g, ok := d.Group(id); ok && len(g.Name) > 0 {
+		return g.Name
+	}
+
+	return id
+}
+
+// ReceiverProblem tells why the bot can't send to the group: it is blocked, the bot is not its member
+// or it is unknown. It is empty when the group is fine or the directory is not loaded yet.
+func (d *Directory) ReceiverProblem(groupId, selfNumber string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.updatedAt.IsZero() {
+		return ""
+	}
+
+	for _, g := range d.groups {
+		if g.InternalId != groupId && g.Id != groupId {
+			continue
+		}
+		if g.Blocked {
+			return "group is blocked"
+		}
+		if !isMember(g.Members, selfNumber) {
+			return "bot is not a member of the group"
+		}
+		return ""
+	}
+
+	return "group is unknown to the bot"
+}
+
+// isMember looks for the number in the members list; the list of uuids only (no numbers are shared
+// with the bot's account) can't be checked, so it is trusted
+func isMember(members []string, number string) bool {
+	hasNumbers := false
+	for _, m := range members {
+		if m == number {
+			return true
+		}
+		hasNumbers = hasNumbers || strings.HasPrefix(m, "+")
+	}
+
+	return !hasNumbers
+}
+
+func (d *Directory) Contact(uuid string) (SignalContact, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	c, ok := d.contacts[uuid]
+	return c, ok
+}
+
+// ContactName returns the contact's name as saved by the bot's account, or its profile name or username;
+// it is empty for unknown contacts
+func (d *Directory) ContactName(uuid string) string {
+	c, ok := d.Contact(uuid)
+	if !ok {
+		return ""
+	}
+
+	for _, name := range []string{c.Name, c.ProfileName, c.Username} {
+		if len(strings.TrimSpace(name)) > 0 {
+			return strings.TrimSpace(name)
+		}
+	}
+
+	return ""
+}
+
+// ResolveGroupName returns the internal id of the group with the given name. A group renamed after the bot
+// has seen it is still found by its former name, with a warning to update the config.
+func (d *Directory) ResolveGroupName(name string) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var matched []SignalGroupEntry
+	for _, g := range d.groups {
+		if strings.EqualFold(g.Name, name) {
+			matched = append(matched, g)
+		}
+	}
+
+	switch len(matched) {
+	case 1:
+		return matched[0].InternalId, nil
+	case 0:
+		id, ok := d.knownIds[strings.ToLower(name)]
+		if ok {
+			for _, g := range d.groups {
+				if g.InternalId == id {
+					Rlog.Infof("group %q is renamed to %q, please update the config", name, g.Name)
+					return id, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("%w: %q", ErrGroupNotFound, name)
+	default:
+		ids := make([]string, len(matched))
+		for i, g := range matched {
+			ids[i] = g.InternalId
+		}
+		return "", fmt.Errorf("group name %q is ambiguous, use one of the ids: %s", name, strings.Join(ids, ", "))
+	}
+}
+
+// ResolveGroupNames sets the group ids of the rules referencing groups by name.
+// Names of the disabled rules which can't be resolved are left unresolved without an error.
+func (c *Config) ResolveGroupNames(dir *Directory) error {
+	for i := range c.Forwarding {
+		rec := &c.Forwarding[i]
+		rec.resolvedGroupId = ""
+		rec.resolvedReceivers = nil
+
+		if len(rec.GroupName) > 0 {
+			id, err := dir.ResolveGroupName(rec.GroupName)
+			if err != nil && rec.IsEnabled {
+				return fmt.Errorf("forwarding group name: %w", err)
+			}
+			rec.resolvedGroupId = id
+		}
+
+		for _, name := range rec.ReceiversGroupNames {
+			id, err := dir.ResolveGroupName(name)
+			if err != nil {
+				if rec.IsEnabled {
+					return fmt.Errorf("forwarding receivers group name: %w", err)
+				}
+				continue
+			}
+			rec.resolvedReceivers = append(rec.resolvedReceivers, id)
+		}
+
+		for j := range rec.Routes {
+			route := &rec.Routes[j]
+			route.resolvedReceivers = nil
+			for _, name := range route.ReceiversGroupNames {
+				id, err := dir.ResolveGroupName(name)
+				if err != nil {
+					if rec.IsEnabled {
+						return fmt.Errorf("forwarding route receivers group name: %w", err)
+					}
+					continue
+				}
+				route.resolvedReceivers = append(route.resolvedReceivers, id)
+			}
+		}
+	}
+
+	if c.Alerts != nil {
+		return c.Alerts.resolveGroupNames(dir)
+	}
+
+	return nil
+}
+
+func (ac *AlertsConfig) resolveGroupNames(dir *Directory) error {
+	var err error
+	if ac.resolvedReceivers, err = resolveNames(dir, ac.ReceiversGroupNames, ac.IsEnabled); err != nil {
+		return fmt.Errorf("alerts receivers group name: %w", err)
+	}
+	for i := range ac.Routes {
+		if ac.Routes[i].resolvedReceivers, err = resolveNames(dir, ac.Routes[i].ReceiversGroupNames, ac.IsEnabled); err != nil {
+			return fmt.Errorf("alerts route receivers group name: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// resolveNames resolves the group names to ids; the unresolved names are skipped unless required
+func resolveNames(dir *Directory, names []string, isRequired bool) ([]string, error) {
+	var ids []string
+	for _, name := range names {
+		id, err := dir.ResolveGroupName(name)
+		if err != nil {
+			if isRequired {
+				return nil, err
+			}
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// RefreshDirectory fetches the groups and contacts and re-resolves the group names of the current config
+func (p *Processor) RefreshDirectory() error {
+	if err := p.directory.Refresh(p.Config()); err != nil {
+		return err
+	}
+
+	p.confMu.Lock()
+	defer p.confMu.Unlock()
+
+	conf, err := p.Config().Clone()
+	if err != nil {
+		return err
+	}
+	if err := conf.ResolveGroupNames(p.directory); err != nil {
+		return err
+	}
+
+	p.conf.Store(conf)
+
+	return nil
+}
+
+func (p *Processor) refreshDirectoryPeriodically() {
+	timer := time.NewTimer(p.Config().DirectoryTTL())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-timer.C:
+			if err := p.RefreshDirectory(); err != nil {
+				Rlog.Error("directory refresh error: ", err)
+			}
+			timer.Reset(p.Config().DirectoryTTL())
+		}
+	}
+}
+
+// Groups returns the cached groups, refreshing the directory first when it is stale
+func (p *Processor) Groups() ([]SignalGroupEntry, error) {
+	if p.directory.IsStale(p.Config().DirectoryTTL()) {
+		if err := p.RefreshDirectory(); err != nil && p.directory.UpdatedAt().IsZero() {
+			return nil, err
+		} else if err != nil {
+			Rlog.Error("directory refresh error, using cached groups: ", err)
+		}
+	}
+
+	return p.directory.Groups(), nil
+}
+
+// expandTemplate fills the {group} and {sender} placeholders of a rule's text from the directory;
+// the sender is shown according to the rule's author_display
+func (p *Processor) expandTemplate(rule *ConfigGroup, tpl string, env *SignalEnvelope) string {
+	if !strings.Contains(tpl, "{") {
+		return tpl
+	}
+
+	return strings.NewReplacer(
+		"{group}", p.directory.GroupName(env.DataMessage.GroupInfo.GroupId),
+		"{sender}", p.AuthorName(rule, env),
+	).Replace(tpl)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SmtpStartTLS = "starttls" //plain connection upgraded with STARTTLS, required (default)
+	SmtpTLS      = "tls"      //implicit TLS, usually on port 465
+	SmtpNone     = "none"     //no encryption, for local relays only
+
+	defaultEmailSubject = "Message from {group}"
+	defaultSmtpTimeout  = 30 * time.Second
+)
+
+type (
+	// SmtpConfig is the mail server used by the email receivers of the rules
+	SmtpConfig struct {
+		Host       string `json:"host"`
+		Port       int    `json:"port,omitempty"`     //587 by default, 465 for tls
+		Security   string `json:"security,omitempty"` //starttls (default), tls or none
+		Username   string `json:"username,omitempty"` //PLAIN auth when set
+		Password   string `json:"password,omitempty"`
+		From       string `json:"from"`
+		TimeoutSec int    `json:"timeout_sec,omitempty"` //for the whole delivery, 30 by default
+	}
+
+	// EmailConfig is a rule's receiver getting the forwarded messages by email
+	EmailConfig struct {
+		To      []string `json:"to"`
+		Subject string   `json:"subject,omitempty"` //{group} and {sender} are replaced, "Message from {group}" by default
+	}
+)
+
+func (sc *SmtpConfig) Validate() error {
+	if sc == nil {
+		return nil
+	}
+
+	sc.Host = strings.TrimSpace(sc.Host)
+	if len(sc.Host) == 0 {
+		return fmt.Errorf("smtp host is required")
+	}
+	if sc.Port < 0 || sc.Port > 65535 || sc.TimeoutSec < 0 {
+		return fmt.Errorf("smtp port and timeout must be valid")
+	}
+	switch sc.Security {
+	case "", SmtpStartTLS, SmtpTLS, SmtpNone:
+	default:
+		return fmt.Errorf("invalid smtp security: %s", sc.Security)
+	}
+	if (len(sc.Username) == 0) != (len(sc.Password) == 0) {
+		return fmt.Errorf("both smtp username and password are required for auth")
+	}
+	if _, err := mail.ParseAddress(sc.From); err != nil {
+		return fmt.Errorf("smtp from: %w", err)
+	}
+
+	return nil
+}
+
+func (sc *SmtpConfig) Addr() string {
+	port := sc.Port
+	if port == 0 {
+		port = 587
+		if sc.Security == SmtpTLS {
+			port = 465
+		}
+	}
+
+	return net.JoinHostPort(sc.Host, strconv.Itoa(port))
+}
+
+func (sc *SmtpConfig) Timeout() time.Duration {
+	if sc.TimeoutSec > 0 {
+		return time.Duration(sc.TimeoutSec) * time.Second
+	}
+
+	return defaultSmtpTimeout
+}
+
+func (ec *EmailConfig) Validate() error {
+	if ec == nil {
+		return nil
+	}
+
+	if len(ec.To) == 0 {
+		return fmt.Errorf("email receivers are required")
+	}
+	for _, to := range ec.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("email receiver: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// SendEmail delivers the message through the SMTP server, encrypted as the security setting says
+func SendEmail(sc *SmtpConfig, to []string, message []byte) error {
+	deadline := time.Now().Add(sc.Timeout())
+	dialer := &net.Dialer{Deadline: deadline}
+	tlsConfig := &tls.Config{ServerName: sc.Host}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if sc.Security == SmtpTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", sc.Addr(), tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", sc.Addr())
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, sc.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if sc.Security == "" || sc.Security == SmtpStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if len(sc.Username) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", sc.Username, sc.Password, sc.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, _ := mail.ParseAddress(sc.From)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		rcpt, err := mail.ParseAddress(addr)
+		if err != nil {
+			return err
+		}
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// BuildEmail makes the MIME message: the text part followed by the attachments
+func BuildEmail(from string, to []string, subject, text string, attachments []DownloadedAttachment, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(addr.Address, "@"); ok {
+			domain = d
+		}
+	}
+
+	header := []string{
+		"From: " + from,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + date.Format(time.RFC1123Z),
+		fmt.Sprintf("Message-ID: <%s@%s>", hex.EncodeToString(id), domain),
+		"MIME-Version: 1.0",
+		fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q", mw.Boundary()),
+	}
+	buf.WriteString(strings.Join(header, "\r\n") + "\r\n\r\n")
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		contentType := a.ContentType
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachmentName(a.SignalAttachments)})},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoded := base64.StdEncoding.EncodeToString(a.Content)
+		for len(encoded) > 76 {
+			if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+				return nil, err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := part.Write([]byte(encoded + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// dispatchEmail sends the forwarded message to the rule's email receivers in the background, with the attachments
+// as prepared for the other receivers; it tells whether the email is sent
+func (p *Processor) dispatchEmail(conf *Config, decision RoutingDecision, envs []*SignalEnvelope, downloaded []DownloadedAttachment) bool {
+	rule := decision.Rule
+	if rule.Email == nil || conf.Smtp == nil {
+		return false
+	}
+
+	subject := rule.Email.Subject
+	if len(subject) == 0 {
+		subject = defaultEmailSubject
+	}
+	sender := ""
+	if len(envs) > 0 && rule.ForwardingMode != FwModeDigest {
+		sender = p.AuthorName(rule, envs[0])
+	}
+	subject = strings.NewReplacer("{group}", p.directory.GroupName(rule.SourceGroupId()), "{sender}", sender).Replace(subject)
+
+	groupId, to := rule.SourceGroupId(), rule.Email.To
+	go func() {
+		message, err := BuildEmail(conf.Smtp.From, to, subject, decision.Text, downloaded, time.Now())
+		if err == nil {
+			Rlog.Infof("SENDING MESSAGE TO EMAIL %s", strings.Join(to, ","))
+			err = SendEmail(conf.Smtp, to, message)
+		}
+		if err != nil {
+			Rlog.Error("email error: ", err)
+			p.activity.Error(groupId, fmt.Errorf("email to %s: %w", strings.Join(to, ","), err))
+		}
+	}()
+
+	return true
+}
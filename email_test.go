@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSmtp is a minimal SMTP server accepting every message, it records the commands and the message data
+type fakeSmtp struct {
+	ln       net.Listener
+	rcptCode string
+
+	mu       sync.Mutex
+	commands []string
+	data     string
+}
+
+func newFakeSmtp(t *testing.T) *fakeSmtp {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	s := &fakeSmtp{ln: ln, rcptCode: "250"}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSmtp) config() *SmtpConfig {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return &SmtpConfig{Host: host, Port: p, Security: SmtpNone, From: "Bot <bot@example.org>", TimeoutSec: 5}
+}
+
+func (s *fakeSmtp) serve(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		_, _ = io.WriteString(conn, strings.Join(lines, "\r\n")+"\r\n")
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO":
+			reply("250-fake", "250 AUTH PLAIN") //no STARTTLS
+		case "AUTH":
+			reply("235 ok")
+		case "MAIL":
+			reply("250 ok")
+		case "RCPT":
+			reply(s.rcptCode + " rcpt")
+		case "DATA":
+			reply("354 go on")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.mu.Lock()
+			s.data = data.String()
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSmtp) recorded() ([]string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...), s.data
+}
+
+func TestSendEmail(t *testing.T) {
+	srv := newFakeSmtp(t)
+	sc := srv.config()
+	sc.Username, sc.Password = "bot", "secret"
+
+	message := []byte("Subject: test\r\n\r\nhello\r\n.dot line\r\n")
+	if err := SendEmail(sc, []string{"Team <team@example.org>", "ops@example.org"}, message); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	commands, data := srv.recorded()
+	joined := strings.Join(commands, "\n")
+	for _, want := range []string{
+		"AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00bot\x00secret")),
+		"MAIL FROM:<bot@example.org>",
+		"RCPT TO:<team@example.org>",
+		"RCPT TO:<ops@example.org>",
+		"DATA",
+		"QUIT",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("command %q is missing in:\n%s", want, joined)
+		}
+	}
+	if data != string(message) {
+		t.Errorf("data = %q", data)
+	}
+}
+
+func TestSendEmailRequiresStartTLS(t *testing.T) {
+	srv := newFakeSmtp(t)
+	sc := srv.config()
+	sc.Security = SmtpStartTLS
+
+	err := SendEmail(sc, []string{"team@example.org"}, []byte("Subject: test\r\n\r\nhello\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("error = %v, want STARTTLS error", err)
+	}
+	if commands, _ := srv.recorded(); strings.Contains(strings.Join(commands, "\n"), "MAIL FROM") {
+		t.Errorf("message is sent without encryption: %v", commands)
+	}
+}
+
+func TestSendEmailRejectedReceiver(t *testing.T) {
+	srv := newFakeSmtp(t)
+	srv.rcptCode = "550"
+
+	if err := SendEmail(srv.config(), []string{"nobody@example.org"}, []byte("\r\n")); err == nil {
+		t.Fatal("rejected receiver error is expected")
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	attachments := []DownloadedAttachment{{
+		SignalAttachments: SignalAttachments{ContentType: "image/png", Filename: "чек.png"},
+		Content:           bytes.Repeat([]byte{0, 1, 2, 250}, 40), //longer than a base64 line
+	}}
+
+	message, err := BuildEmail("Bot <bot@example.org>", []string{"team@example.org"}, "Новости группы", "line 1\nline 2 ünïcode", attachments, date)
+	if err != nil {
+		t.Fatalf("build error: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("message parse error: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Новости группы" {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+	if msg.Header.Get("To") != "team@example.org" || !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.org>") {
+		t.Errorf("header = %v", msg.Header)
+	}
+	if got, _ := msg.Header.Date(); !got.Equal(date) {
+		t.Errorf("date = %v", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type = %q, %v", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	text, err := mr.NextRawPart() //NextPart would decode the quoted-printable itself
+	if err != nil {
+		t.Fatalf("text part error: %v", err)
+	}
+	body, _ := io.ReadAll(quotedprintable.NewReader(text))
+	if string(body) != "line 1\r\nline 2 ünïcode" {
+		t.Errorf("text = %q", body)
+	}
+
+	file, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("attachment part error: %v", err)
+	}
+	if file.Header.Get("Content-Type") != "image/png" || file.FileName() != "чек.png" {
+		t.Errorf("attachment header = %v", file.Header)
+	}
+	encoded, _ := io.ReadAll(file)
+	for _, line := range strings.Split(strings.TrimSpace(string(encoded)), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("base64 line is too long: %d", len(line))
+		}
+	}
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(content, attachments[0].Content) {
+		t.Errorf("attachment content = %v, %v", content, err)
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("unexpected part: %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultJpegQuality = 90         //when only resizing or stripping the metadata
+	maxImagePixels     = 50_000_000 //larger images are not decoded, a small file may declare a huge image
+
+	exifOrientationTag = 0x0112
+	glyphWidth         = 5
+	glyphHeight        = 7
+)
+
+// ProcessImage applies the rule's image settings to the downloaded JPEG or PNG attachment, other files are left as is.
+// Re-encoding drops all the metadata, so the EXIF orientation is applied to the pixels first. The other images, like
+// HEIC or WebP photos, can't be re-encoded: with strip_metadata they fail, so they are not sent with their metadata.
+func ProcessImage(ic *ImageConfig, a *DownloadedAttachment, watermark string) error {
+	contentType := strings.ToLower(a.ContentType)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		if ic.StripMetadata && strings.HasPrefix(contentType, "image/") {
+			return fmt.Errorf("metadata of %s images can't be stripped", contentType)
+		}
+		return nil
+	}
+	if ic.MaxWidth == 0 && ic.MaxHeight == 0 && ic.JpegQuality == 0 && !ic.StripMetadata && len(watermark) == 0 {
+		return nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(a.Content))
+	if err != nil {
+		return err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return fmt.Errorf("image size %dx%d is over the limit of %d pixels", cfg.Width, cfg.Height, maxImagePixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(a.Content))
+	if err != nil {
+		return err
+	}
+
+	img := toRGBA(src)
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(a.Content))
+	}
+	if w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), ic.MaxWidth, ic.MaxHeight); w != img.Bounds().Dx() || h != img.Bounds().Dy() {
+		img = downscale(img, w, h)
+	}
+	if len(watermark) > 0 {
+		drawWatermark(img, watermark)
+	}
+
+	var out bytes.Buffer
+	if contentType == "image/png" && ic.JpegQuality == 0 {
+		err = png.Encode(&out, img)
+	} else {
+		quality := ic.JpegQuality
+		if quality == 0 {
+			quality = defaultJpegQuality
+		}
+		if contentType == "image/png" {
+			img = flatten(img) //JPEG has no transparency
+		}
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: quality})
+		a.ContentType = "image/jpeg"
+		if len(a.Filename) > 0 {
+			a.Filename = strings.TrimSuffix(a.Filename, filepath.Ext(a.Filename)) + ".jpg"
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	a.Content = out.Bytes()
+	a.Size = uint64(out.Len())
+	a.Width, a.Height = uint(img.Bounds().Dx()), uint(img.Bounds().Dy())
+
+	return nil
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	return dst
+}
+
+// flatten draws the image over the white background
+func flatten(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, image.Point{}, draw.Over)
+
+	return dst
+}
+
+// fitSize returns the size within the limits (0 for no limit), keeping the aspect ratio; images are never upscaled
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = min(scale, float64(maxW)/float64(w))
+	}
+	if maxH > 0 && h > maxH {
+		scale = min(scale, float64(maxH)/float64(h))
+	}
+	if scale == 1.0 {
+		return w, h
+	}
+
+	return max(1, int(float64(w)*scale+0.5)), max(1, int(float64(h)*scale+0.5))
+}
+
+// downscale averages the source pixels covered by every destination pixel
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0 := y * sh / h
+		y1 := max((y+1)*sh/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := x * sw / w
+			x1 := max((x+1)*sw/w, x0+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			off := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[off+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// orient turns the pixels as the EXIF orientation (2-8) says, so the image is shown right without the metadata
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: //mirrored
+				dx, dy = w-1-x, y
+			case 3: //rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: //mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: //transposed
+				dx, dy = y, x
+			case 6: //rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: //transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: //rotated 90 counterclockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation finds the orientation in the EXIF segment of the JPEG, 1 (normal) when there is none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: //fill byte
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): //markers without a segment
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9: //image data, no metadata after it
+			return 1
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of the TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+
+	return 1
+}
+
+// drawWatermark draws the text in white on a translucent box in the bottom right corner, cut to the image width
+func drawWatermark(img *image.RGBA, text string) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := max(1, min(w, h)/250)
+	pad := 2 * scale
+	advance := (glyphWidth + 1) * scale
+
+	runes := []rune(strings.ToUpper(text))
+	if fit := (w - 2*pad + scale) / advance; len(runes) > fit {
+		runes = runes[:max(fit, 0)]
+	}
+	if len(runes) == 0 || h < glyphHeight*scale+2*pad {
+		return
+	}
+
+	textW, textH := len(runes)*advance-scale, glyphHeight*scale
+	box := image.Rect(w-textW-2*pad, h-textH-2*pad, w, h)
+	draw.Draw(img, box, image.NewUniform(color.NRGBA{A: 128}), image.Point{}, draw.Over)
+
+	for i, r := range runes {
+		glyph, ok := watermarkFont[r]
+		if !ok {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			glyph = watermarkFont['?']
+		}
+
+		x0, y0 := box.Min.X+pad+i*advance, box.Min.Y+pad
+		for row, bits := range strings.Fields(glyph) {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				dot := image.Rect(x0+col*scale, y0+row*scale, x0+(col+1)*scale, y0+(row+1)*scale)
+				draw.Draw(img, dot, image.White, image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// watermarkFont is a 5x7 bitmap font of the upper case Latin letters, digits and common punctuation, rows are space separated
+var watermarkFont = map[rune]string{
+	'A':  ".###. #...# #...# ##### #...# #...# #...#",
+	'B':  "####. #...# #...# ####. #...# #...# ####.",
+	'C':  ".###. #...# #.... #.... #.... #...# .###.",
+	'D':  "####. #...# #...# #...# #...# #...# ####.",
+	'E':  "##### #.... #.... ####. #.... #.... #####",
+	'F':  "##### #.... #.... ####. #.... #.... #....",
+	'G':  ".###. #...# #.... #.### #...# #...# .####",
+	'H':  "#...# #...# #...# ##### #...# #...# #...#",
+	'I':  ".###. ..#.. ..#.. ..#.. ..#.. ..#.. .###.",
+	'J':  "..### ...#. ...#. ...#. ...#. #..#. .##..",
+	'K':  "#...# #..#. #.#.. ##... #.#.. #..#. #...#",
+	'L':  "#.... #.... #.... #.... #.... #.... #####",
+	'M':  "#...# ##.## #.#.# #.#.# #...# #...# #...#",
+	'N':  "#...# #...# ##..# #.#.# #..## #...# #...#",
+	'O':  ".###. #...# #...# #...# #...# #...# .###.",
+	'P':  "####. #...# #...# ####. #.... #.... #....",
+	'Q':  ".###. #...# #...# #...# #.#.# #..#. .##.#",
+	'R':  "####. #...# #...# ####. #.#.. #..#. #...#",
+	'S':  ".#### #.... #.... .###. ....# ....# ####.",
+	'T':  "##### ..#.. ..#.. ..#.. ..#.. ..#.. ..#..",
+	'U':  "#...# #...# #...# #...# #...# #...# .###.",
+	'V':  "#...# #...# #...# #...# #...# .#.#. ..#..",
+	'W':  "#...# #...# #...# #.#.# #.#.# #.#.# .#.#.",
+	'X':  "#...# #...# .#.#. ..#.. .#.#. #...# #...#",
+	'Y':  "#...# #...# .#.#. ..#.. ..#.. ..#.. ..#..",
+	'Z':  "##### ....# ...#. ..#.. .#... #.... #####",
+	'0':  ".###. #...# #..## #.#.# ##..# #...# .###.",
+	'1':  "..#.. .##.. ..#.. ..#.. ..#.. ..#.. .###.",
+	'2':  ".###. #...# ....# ...#. ..#.. .#... #####",
+	'3':  "##### ...#. ..#.. ...#. ....# #...# .###.",
+	'4':  "...#. ..##. .#.#. #..#. ##### ...#. ...#.",
+	'5':  "##### #.... ####. ....# ....# #...# .###.",
+	'6':  "..##. .#... #.... ####. #...# #...# .###.",
+	'7':  "##### ....# ...#. ..#.. .#... .#... .#...",
+	'8':  ".###. #...# #...# .###. #...# #...# .###.",
+	'9':  ".###. #...# #...# .#### ....# ...#. .##..",
+	'-':  "..... ..... ..... ##### ..... ..... .....",
+	'_':  "..... ..... ..... ..... ..... ..... #####",
+	'.':  "..... ..... ..... ..... ..... .##.. .##..",
+	',':  "..... ..... ..... ..... .##.. ..#.. .#...",
+	':':  "..... .##.. .##.. ..... .##.. .##.. .....",
+	'!':  "..#.. ..#.. ..#.. ..#.. ..#.. ..... ..#..",
+	'?':  ".###. #...# ....# ...#. ..#.. ..... ..#..",
+	'\'': "..#.. ..#.. .#... ..... ..... ..... .....",
+	'(':  "...#. ..#.. .#... .#... .#... ..#.. ...#.",
+	')':  ".#... ..#.. ...#. ...#. ...#. ..#.. .#...",
+	'#':  ".#.#. .#.#. ##### .#.#. ##### .#.#. .#.#.",
+	'/':  "..... ....# ...#. ..#.. .#... #.... .....",
+	'&':  ".##.. #..#. #.#.. .#... #.#.# #..#. .##.#",
+	'@':  ".###. #...# ....# .##.# #.#.# #.#.# .###.",
+	'+':  "..... ..#.. ..#.. ##### ..#.. ..#.. .....",
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestProcessImageTypes(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		ic          ImageConfig
+		contentType string
+		content     []byte
+		wantType    string
+		wantErr     bool
+	}{
+		{name: "png is stripped", ic: ImageConfig{StripMetadata: true}, contentType: "image/png", content: encoded.Bytes(), wantType: "image/png"},
+		{name: "png to jpeg", ic: ImageConfig{JpegQuality: 80}, contentType: "image/png", content: encoded.Bytes(), wantType: "image/jpeg"},
+		{name: "heic can't be stripped", ic: ImageConfig{StripMetadata: true}, contentType: "image/heic", content: []byte("heic"), wantErr: true},
+		{name: "webp can't be stripped", ic: ImageConfig{StripMetadata: true, MaxWidth: 100}, contentType: "image/webp", content: []byte("webp"), wantErr: true},
+		{name: "heic is left as is", ic: ImageConfig{MaxWidth: 100}, contentType: "image/heic", content: []byte("heic"), wantType: "image/heic"},
+		{name: "other files are left as is", ic: ImageConfig{StripMetadata: true}, contentType: "application/pdf", content: []byte("pdf"), wantType: "application/pdf"},
+		{name: "broken png", ic: ImageConfig{StripMetadata: true}, contentType: "image/png", content: []byte("png"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := DownloadedAttachment{SignalAttachments: SignalAttachments{ContentType: tt.contentType, Filename: "file"}, Content: tt.content}
+			err := ProcessImage(&tt.ic, &a, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && a.ContentType != tt.wantType {
+				t.Errorf("content type = %s, want %s", a.ContentType, tt.wantType)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 func main() {
 	configPath := flag.String("cp", "config.json", "-cp=/path/to/config.json")
+	replayPath := flag.String("replay", "", "-replay=/path/to/capture.jsonl")
 
 	flag.Parse()
 	log.SetFlags(0)
@@ -18,10 +19,31 @@ func main() {
 		return
 	}
 
-	api := newAPI(conf)
-	go api.ConfigureRoutes()
+	Rlog.SetNumbersAllowed(conf.AllowNumbers)
 
-	err = initWebsocketClient(conf)
+	if len(*replayPath) > 0 {
+		err = ReplayCapture(conf, *replayPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	processor, err := NewProcessor(conf)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	api := newAPI(processor)
+	go func() {
+		err := api.ConfigureRoutes()
+		if err != nil {
+			Rlog.Fatal("api error: ", err)
+		}
+	}()
+
+	err = initWebsocketClient(processor)
 	if err != nil {
 		Rlog.Fatal("initWebsocketClient error: ", err)
 	}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	MatrixConfig struct {
+		HomeserverURL string `json:"homeserver_url"` //like https://matrix.example.org
+		AccessToken   string `json:"access_token"`   //of the bot's account, joined to the rooms
+		TimeoutSec    int    `json:"timeout_sec,omitempty"`
+	}
+
+	// MatrixSink posts to the rooms with the client-server API
+	MatrixSink struct {
+		conf   *MatrixConfig
+		client *http.Client
+	}
+
+	matrixResponse struct {
+		ErrCode    string `json:"errcode"`
+		Error      string `json:"error"`
+		EventId    string `json:"event_id"`
+		ContentURI string `json:"content_uri"`
+		RoomId     string `json:"room_id"`
+	}
+)
+
+var matrixTxnCounter atomic.Uint64
+
+func (mc *MatrixConfig) Validate() error {
+	if mc == nil {
+		return nil
+	}
+
+	u, err := url.Parse(mc.HomeserverURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("matrix homeserver url must be an http(s) url")
+	}
+	if len(mc.AccessToken) == 0 {
+		return fmt.Errorf("matrix access token is required")
+	}
+	if mc.TimeoutSec < 0 {
+		return fmt.Errorf("matrix timeout must not be negative")
+	}
+
+	return nil
+}
+
+func NewMatrixSink(mc *MatrixConfig) *MatrixSink {
+	return &MatrixSink{conf: mc, client: &http.Client{Timeout: sinkTimeout(mc.TimeoutSec)}}
+}
+
+// Capabilities: the events are replaced (m.replace), redacted and annotated with the reactions
+func (s *MatrixSink) Capabilities() SinkCapabilities {
+	return SinkCapabilities{Edit: true, Delete: true, React: true}
+}
+
+func (s *MatrixSink) SendText(room string, text string) (MessageRef, error) {
+	return s.SendAttachments(room, text, nil)
+}
+
+// SendAttachments posts the text and then every attachment as a separate event, uploading its content first;
+// the reference is the text event, or the first attachment's one without the text
+func (s *MatrixSink) SendAttachments(room string, text string, attachments []DownloadedAttachment) (MessageRef, error) {
+	roomId, err := s.roomId(room)
+	if err != nil {
+		return "", err
+	}
+
+	var ref MessageRef
+	if len(text) > 0 {
+		if ref, err = s.sendEvent(roomId, "m.room.message", map[string]any{"msgtype": "m.text", "body": text}); err != nil {
+			return "", err
+		}
+	}
+
+	for _, a := range attachments {
+		uri, err := s.upload(a)
+		if err != nil {
+			return ref, err
+		}
+
+		info := map[string]any{"mimetype": a.ContentType, "size": len(a.Content)}
+		if a.Width > 0 && a.Height > 0 {
+			info["w"], info["h"] = a.Width, a.Height
+		}
+		name := attachmentName(a.SignalAttachments)
+		content := map[string]any{"msgtype": matrixMsgType(a.ContentType), "body": name, "filename": name, "url": uri, "info": info}
+		eventId, err := s.sendEvent(roomId, "m.room.message", content)
+		if err != nil {
+			return ref, err
+		}
+		if len(ref) == 0 {
+			ref = eventId
+		}
+	}
+
+	return ref, nil
+}
+
+// Edit replaces the text event with the m.replace relation, the clients not supporting edits show the "* text" fallback
+func (s *MatrixSink) Edit(room string, ref MessageRef, text string) error {
+	roomId, err := s.roomId(room)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.sendEvent(roomId, "m.room.message", map[string]any{
+		"msgtype":       "m.text",
+		"body":          "* " + text,
+		"m.new_content": map[string]any{"msgtype": "m.text", "body": text},
+		"m.relates_to":  map[string]any{"rel_type": "m.replace", "event_id": string(ref)},
+	})
+
+	return err
+}
+
+// Delete redacts the event
+func (s *MatrixSink) Delete(room string, ref MessageRef) error {
+	roomId, err := s.roomId(room)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/redact/%s/%s", url.PathEscape(roomId), url.PathEscape(string(ref)), matrixTxnId())
+
+	var res matrixResponse
+	status, err := sinkRequest(s.client, "PUT", s.url(path), s.header(), map[string]any{}, &res)
+
+	return matrixError(status, err, res)
+}
+
+func (s *MatrixSink) React(room string, ref MessageRef, emoji string) error {
+	roomId, err := s.roomId(room)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.sendEvent(roomId, "m.reaction", map[string]any{
+		"m.relates_to": map[string]any{"rel_type": "m.annotation", "event_id": string(ref), "key": emoji},
+	})
+
+	return err
+}
+
+func (s *MatrixSink) url(path string) string {
+	return strings.TrimRight(s.conf.HomeserverURL, "/") + path
+}
+
+func (s *MatrixSink) header() http.Header {
+	return http.Header{"Authorization": {"Bearer " + s.conf.AccessToken}}
+}
+
+// roomId resolves the room alias (#alias:server), room ids are returned as is
+func (s *MatrixSink) roomId(room string) (string, error) {
+	if !strings.HasPrefix(room, "#") {
+		return room, nil
+	}
+
+	var res matrixResponse
+	status, err := sinkRequest(s.client, "GET", s.url("/_matrix/client/v3/directory/room/"+url.PathEscape(room)), s.header(), nil, &res)
+	if err := matrixError(status, err, res); err != nil {
+		return "", fmt.Errorf("matrix room alias %s: %w", room, err)
+	}
+
+	return res.RoomId, nil
+}
+
+func (s *MatrixSink) sendEvent(roomId string, eventType string, content map[string]any) (MessageRef, error) {
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/%s/%s", url.PathEscape(roomId), eventType, matrixTxnId())
+
+	var res matrixResponse
+	status, err := sinkRequest(s.client, "PUT", s.url(path), s.header(), content, &res)
+	if err := matrixError(status, err, res); err != nil {
+		return "", err
+	}
+
+	return MessageRef(res.EventId), nil
+}
+
+// matrixTxnId makes the transaction id, unique for the access token
+func matrixTxnId() string {
+	return fmt.Sprintf("replicator-%d-%d", time.Now().UnixNano(), matrixTxnCounter.Add(1))
+}
+
+// upload stores the attachment in the media repository, returning its mxc:// uri
+func (s *MatrixSink) upload(a DownloadedAttachment) (string, error) {
+	header := s.header()
+	header.Set("Content-Type", a.ContentType)
+	u := s.url("/_matrix/media/v3/upload?filename=" + url.QueryEscape(attachmentName(a.SignalAttachments)))
+
+	var res matrixResponse
+	status, err := sinkRequest(s.client, "POST", u, header, bytes.NewReader(a.Content), &res)
+	if err := matrixError(status, err, res); err != nil {
+		return "", fmt.Errorf("matrix upload: %w", err)
+	}
+
+	return res.ContentURI, nil
+}
+
+func matrixError(status int, err error, res matrixResponse) error {
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("matrix bad status %d: %s %s", status, res.ErrCode, res.Error)
+	}
+
+	return nil
+}
+
+func matrixMsgType(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		return "m.image"
+	case strings.HasPrefix(contentType, "video/"):
+		return "m.video"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "m.audio"
+	default:
+		return "m.file"
+	}
+}
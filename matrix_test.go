@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type sinkCall struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// sinkServer records the requests and answers them with the handler's status and JSON
+type sinkServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	calls []sinkCall
+}
+
+func newSinkServer(t *testing.T, handler func(call sinkCall) (int, any)) *sinkServer {
+	s := &sinkServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		call := sinkCall{Method: r.Method, Path: r.URL.EscapedPath(), Header: r.Header.Clone(), Body: body}
+		if len(r.URL.RawQuery) > 0 {
+			call.Path += "?" + r.URL.RawQuery
+		}
+		s.mu.Lock()
+		s.calls = append(s.calls, call)
+		s.mu.Unlock()
+
+		status, res := handler(call)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *sinkServer) recorded() []sinkCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]sinkCall(nil), s.calls...)
+}
+
+func jsonBody(t *testing.T, call sinkCall) map[string]any {
+	t.Helper()
+
+	var body map[string]any
+	if err := json.Unmarshal(call.Body, &body); err != nil {
+		t.Fatalf("%s %s body error: %v", call.Method, call.Path, err)
+	}
+
+	return body
+}
+
+func TestMatrixSendAttachments(t *testing.T) {
+	events := 0
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		switch {
+		case strings.HasPrefix(call.Path, "/_matrix/client/v3/directory/room/"):
+			return 200, map[string]string{"room_id": "!room:example.org"}
+		case strings.HasPrefix(call.Path, "/_matrix/media/v3/upload"):
+			return 200, map[string]string{"content_uri": "mxc://example.org/media"}
+		default:
+			events++
+			return 200, map[string]string{"event_id": "$event" + string(rune('0'+events))}
+		}
+	})
+
+	sink := NewMatrixSink(&MatrixConfig{HomeserverURL: srv.URL + "/", AccessToken: "token"})
+	attachment := DownloadedAttachment{
+		SignalAttachments: SignalAttachments{ContentType: "image/png", Filename: "pic.png", Width: 2, Height: 1},
+		Content:           []byte("png"),
+	}
+	ref, err := sink.SendAttachments("#news:example.org", "hello", []DownloadedAttachment{attachment})
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if ref != "$event1" {
+		t.Errorf("ref = %q, want the text event", ref)
+	}
+
+	calls := srv.recorded()
+	if len(calls) != 4 {
+		t.Fatalf("calls = %d: %+v", len(calls), calls)
+	}
+	for _, call := range calls {
+		if call.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("%s has no access token", call.Path)
+		}
+	}
+	if calls[0].Method != "GET" || calls[0].Path != "/_matrix/client/v3/directory/room/%23news:example.org" {
+		t.Errorf("alias resolution = %s %s", calls[0].Method, calls[0].Path)
+	}
+
+	if !strings.HasPrefix(calls[1].Path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/") || calls[1].Method != "PUT" {
+		t.Errorf("text event = %s %s", calls[1].Method, calls[1].Path)
+	}
+	if body := jsonBody(t, calls[1]); body["msgtype"] != "m.text" || body["body"] != "hello" {
+		t.Errorf("text event body = %v", body)
+	}
+
+	if calls[2].Path != "/_matrix/media/v3/upload?filename=pic.png" || string(calls[2].Body) != "png" || calls[2].Header.Get("Content-Type") != "image/png" {
+		t.Errorf("upload = %s %s %q", calls[2].Path, calls[2].Header.Get("Content-Type"), calls[2].Body)
+	}
+
+	body := jsonBody(t, calls[3])
+	info, _ := body["info"].(map[string]any)
+	if body["msgtype"] != "m.image" || body["url"] != "mxc://example.org/media" || body["body"] != "pic.png" || info["w"] != 2.0 || info["h"] != 1.0 {
+		t.Errorf("image event body = %v", body)
+	}
+	if calls[1].Path == calls[3].Path {
+		t.Error("transaction ids are repeated")
+	}
+}
+
+func TestMatrixEditDeleteReact(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 200, map[string]string{"event_id": "$new"}
+	})
+	sink := NewMatrixSink(&MatrixConfig{HomeserverURL: srv.URL, AccessToken: "token"})
+
+	if err := sink.Edit("!room:example.org", "$orig", "fixed"); err != nil {
+		t.Fatalf("edit error: %v", err)
+	}
+	if err := sink.Delete("!room:example.org", "$orig"); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := sink.React("!room:example.org", "$orig", "👍"); err != nil {
+		t.Fatalf("react error: %v", err)
+	}
+
+	calls := srv.recorded()
+	if len(calls) != 3 {
+		t.Fatalf("calls = %d", len(calls))
+	}
+	if !strings.HasPrefix(calls[0].Path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/") {
+		t.Errorf("edit path = %s", calls[0].Path)
+	}
+
+	edit := jsonBody(t, calls[0])
+	relates, _ := edit["m.relates_to"].(map[string]any)
+	content, _ := edit["m.new_content"].(map[string]any)
+	if edit["body"] != "* fixed" || content["body"] != "fixed" || relates["rel_type"] != "m.replace" || relates["event_id"] != "$orig" {
+		t.Errorf("edit body = %v", edit)
+	}
+
+	if !strings.HasPrefix(calls[1].Path, "/_matrix/client/v3/rooms/%21room:example.org/redact/$orig/") || calls[1].Method != "PUT" {
+		t.Errorf("redact = %s %s", calls[1].Method, calls[1].Path)
+	}
+
+	if !strings.Contains(calls[2].Path, "/send/m.reaction/") {
+		t.Errorf("reaction path = %s", calls[2].Path)
+	}
+	reaction, _ := jsonBody(t, calls[2])["m.relates_to"].(map[string]any)
+	if reaction["rel_type"] != "m.annotation" || reaction["key"] != "👍" || reaction["event_id"] != "$orig" {
+		t.Errorf("reaction = %v", reaction)
+	}
+}
+
+func TestMatrixErrors(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 403, map[string]string{"errcode": "M_FORBIDDEN", "error": "not in room"}
+	})
+	sink := NewMatrixSink(&MatrixConfig{HomeserverURL: srv.URL, AccessToken: "token"})
+
+	_, err := sink.SendText("!room:example.org", "hello")
+	if err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "M_FORBIDDEN") {
+		t.Errorf("error = %v", err)
+	}
+
+	if _, err := sink.SendText("#missing:example.org", "hello"); err == nil || !strings.Contains(err.Error(), "#missing:example.org") {
+		t.Errorf("alias error = %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type AuthorDisplay string
+
+const (
+	AuthorFullName  AuthorDisplay = "full_name"
+	AuthorFirstName AuthorDisplay = "first_name"
+	AuthorPseudonym AuthorDisplay = "pseudonym"
+	AuthorHidden    AuthorDisplay = "hidden"
+)
+
+var phoneNumberRe = regexp.MustCompile(`\+[0-9]{6,15}`)
+
+func (ad AuthorDisplay) Validate() error {
+	switch ad {
+	case "", AuthorFullName, AuthorFirstName, AuthorPseudonym, AuthorHidden:
+		return nil
+	default:
+		return fmt.Errorf("invalid author display: %s", ad)
+	}
+}
+
+// IsShown tells whether the author line is added to the forwarded messages
+func (ad AuthorDisplay) IsShown() bool {
+	return len(ad) > 0 && ad != AuthorHidden
+}
+
+// AuthorName returns the envelope's author as the rule's author_display allows to show it: the contact name
+// (or the profile name), its first word, a pseudonym, or nothing when hidden. The phone number is used only
+// when allow_numbers is set and there is no name; otherwise the pseudonym or "Unknown" is used.
+func (p *Processor) AuthorName(rule *ConfigGroup, env *SignalEnvelope) string {
+	conf := p.Config()
+
+	switch rule.AuthorDisplay {
+	case AuthorHidden:
+		return ""
+	case AuthorPseudonym:
+		return Pseudonym(conf.PseudonymSecret, env.SourceUuid)
+	}
+
+	name := p.directory.ContactName(env.SourceUuid)
+	if len(name) == 0 {
+		name = strings.TrimSpace(env.SourceName)
+	}
+	if !conf.AllowNumbers && phoneNumberRe.MatchString(name) {
+		name = "" //unnamed contacts may be named by their numbers
+	}
+
+	if len(name) == 0 {
+		switch {
+		case conf.AllowNumbers && len(env.SourceNumber) > 0:
+			return env.SourceNumber
+		case len(conf.PseudonymSecret) > 0:
+			return Pseudonym(conf.PseudonymSecret, env.SourceUuid)
+		default:
+			return "Unknown"
+		}
+	}
+
+	if rule.AuthorDisplay == AuthorFirstName {
+		return strings.Fields(name)[0]
+	}
+
+	return name
+}
+
+// AuthorUuid returns the envelope's author uuid unless the rule hides the author or shows it by pseudonym
+func AuthorUuid(rule *ConfigGroup, env *SignalEnvelope) string {
+	if rule.AuthorDisplay == AuthorHidden || rule.AuthorDisplay == AuthorPseudonym {
+		return ""
+	}
+
+	return env.SourceUuid
+}
+
+// Pseudonym is a stable anonymous name of the author: HMAC-SHA256 of the uuid with the secret
+func Pseudonym(secret, uuid string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(uuid))
+
+	return "Anonymous " + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// withAuthor prepends the author line to the forwarded text
+func withAuthor(author, text string) string {
+	if len(text) == 0 {
+		return author + ":"
+	}
+
+	return author + ":\n" + text
+}
+
+// RedactNumbers masks the phone numbers in s, keeping the last two digits
+func RedactNumbers(s string) string {
+	return phoneNumberRe.ReplaceAllStringFunc(s, func(n string) string {
+		return "+" + strings.Repeat("*", len(n)-3) + n[len(n)-2:]
+	})
+}
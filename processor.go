@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,16 +15,21 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func initWebsocketClient(conf *Config) error {
-	if conf == nil {
-		return errors.New("config is nil")
+func initWebsocketClient(processor *Processor) error {
+	if processor == nil {
+		return errors.New("processor is nil")
 	}
+	conf := processor.Config()
 
 	Rlog.SetDebugEnabled(conf.EnableDebugMessages)
+	Rlog.SetNumbersAllowed(conf.AllowNumbers)
 
 	Rlog.Info("Starting Client")
 
@@ -45,6 +52,18 @@ func initWebsocketClient(conf *Config) error {
 	}(c)
 
 	Rlog.Infof("ws connected %s", u.String())
+	processor.activity.SetConnected(true)
+	defer processor.activity.SetConnected(false)
+
+	processor.Start()
+	defer processor.Close()
+
+	capture, err := NewCapture(conf.Capture)
+	if err != nil {
+		Rlog.Error("capture init error: ", err)
+		return err
+	}
+	defer capture.Close()
 
 	done := make(chan struct{})
 
@@ -54,160 +73,13 @@ func initWebsocketClient(conf *Config) error {
 			_, message, err := c.ReadMessage()
 			if err != nil {
 				Rlog.Error("read error: ", err)
-				continue
+				processor.activity.SetConnected(false)
+				processor.activity.Error("", fmt.Errorf("websocket read: %w", err))
+				return //the connection is broken, further reads fail as well
 			}
 
-			var msg SignalMessage
-
-			err = json.Unmarshal(message, &msg)
-			if err != nil {
-				Rlog.Error("decode error: ", err.Error())
-				continue
-			}
-
-			now := uint64(time.Now().UTC().UnixMilli())
-			if (now - msg.Envelope.Timestamp) > conf.IgnoreOlderMessages {
-				Rlog.Debugf("Now is %d, but message is from %d; diff is %d (>%d)", now, msg.Envelope.Timestamp, now-msg.Envelope.Timestamp, conf.IgnoreOlderMessages)
-				continue //this is sync message, will be ignored
-			}
-
-			if conf.IsPrintMessages && (len(msg.Envelope.DataMessage.Message) > 0 || len(msg.Envelope.DataMessage.Attachments) > 0) {
-				Rlog.Infof("Message: %s, Author: %s, Author UUID: %s, Attachments: %d, Group: %s",
-					msg.Envelope.DataMessage.Message,
-					msg.Envelope.Source,
-					msg.Envelope.SourceUuid,
-					len(msg.Envelope.DataMessage.Attachments),
-					msg.Envelope.DataMessage.GroupInfo.GroupId,
-				)
-			}
-
-			rec, err := GetForwardingRecord(conf, msg.Envelope.DataMessage.GroupInfo.GroupId)
-			if err != nil {
-				Rlog.Error("GetForwardingRecord:", err)
-				continue
-			}
-			if rec == nil {
-				Rlog.Debugf("GroupId %s is not found in forwarding list, ignoring", msg.Envelope.DataMessage.GroupInfo.GroupId)
-				continue
-			}
-
-			Rlog.Debugf("recv: %s", message)
-
-			switch rec.ForwardingMode {
-			case FwModeAttachments:
-				if len(msg.Envelope.DataMessage.Attachments) > 0 {
-					if !conf.IsSendingEnabled {
-						Rlog.Debug("sending messages disabled")
-						continue
-					}
-
-					m, err := CheckFilters(conf, rec, &msg.Envelope, false)
-					if err != nil {
-						Rlog.Error("check filters error:", err)
-						continue
-					}
-
-					if len(m) == 0 {
-						Rlog.Debugf("filtered message, ignoring...")
-						continue
-					}
-
-					err = SendMessage(conf, rec.ReceiversGroupIds, msg.Envelope.DataMessage.Attachments, rec.BotSpecialAddonMsg)
-					if err != nil {
-						Rlog.Error("send message error:", err)
-					}
-
-					err = MarkMessageAsRead(conf, msg.Envelope.Source, msg.Envelope.Timestamp) //TODO: this doesn't has any effect (
-					if err != nil {
-						Rlog.Error("mark message as read error:", err)
-					}
-
-					err = SendMessageReaction(conf, rec.ReactionMark, msg.Envelope.Source, msg.Envelope.Source, msg.Envelope.Timestamp)
-					if err != nil {
-						Rlog.Error("send message reaction error:", err)
-					}
-				} else {
-					Rlog.Debug("message has no attachments")
-					continue
-				}
-				break
-			case FwModeMessages:
-				if len(msg.Envelope.DataMessage.Message) > 0 && len(msg.Envelope.DataMessage.Attachments) == 0 {
-					if !conf.IsSendingEnabled {
-						Rlog.Info("sending messages disabled")
-						continue
-					}
-
-					m, err := CheckFilters(conf, rec, &msg.Envelope, true)
-					if err != nil {
-						Rlog.Error("check filters error:", err)
-						continue
-					}
-
-					err = SendMessage(conf, rec.ReceiversGroupIds, make([]SignalAttachments, 0), m)
-					if err != nil {
-						Rlog.Error("send message error:", err)
-					}
-
-					err = MarkMessageAsRead(conf, msg.Envelope.Source, msg.Envelope.Timestamp) //TODO: this doesn't has any effect (
-					if err != nil {
-						Rlog.Error("mark message as read error:", err)
-					}
-
-					err = SendMessageReaction(conf, rec.ReactionMark, msg.Envelope.Source, msg.Envelope.Source, msg.Envelope.Timestamp)
-					if err != nil {
-						Rlog.Error("send message reaction error:", err)
-					}
-				}
-				break
-			case FwModeAll:
-				if !conf.IsSendingEnabled {
-					Rlog.Debug("sending messages disabled")
-					continue
-				}
-
-				m, err := CheckFilters(conf, rec, &msg.Envelope, false)
-				if err != nil {
-					Rlog.Error("check filters error:", err)
-					continue
-				}
-
-				if len(m) == 0 {
-					Rlog.Debugf("filtered message, ignoring...")
-					continue
-				}
-
-				err = SendMessage(conf, rec.ReceiversGroupIds, msg.Envelope.DataMessage.Attachments, m)
-				if err != nil {
-					Rlog.Error("send message error:", err)
-				}
-
-				err = MarkMessageAsRead(conf, msg.Envelope.Source, msg.Envelope.Timestamp) //TODO: this doesn't has any effect (
-				if err != nil {
-					Rlog.Error("mark message as read error:", err)
-				}
-
-				err = SendMessageReaction(conf, rec.ReactionMark, msg.Envelope.Source, msg.Envelope.Source, msg.Envelope.Timestamp)
-				if err != nil {
-					Rlog.Error("send message reaction error:", err)
-				}
-				break
-			}
-
-			if len(msg.Envelope.DataMessage.Attachments) > 0 {
-				for _, rep := range conf.Forwarding {
-					if !rep.IsEnabled {
-						Rlog.Debugf("record for group  %s is disabled, ignoring", rep.GroupId)
-						continue
-					}
-
-					if strings.EqualFold(rep.GroupId, msg.Envelope.DataMessage.GroupInfo.GroupId) {
-						Rlog.Debugf("recv: %s", message)
-					} else {
-						Rlog.Debugf("rep.GroupId %s != msg.Envelope.DataMessage.GroupInfo.GroupId %s", rep.GroupId, msg.Envelope.DataMessage.GroupInfo.GroupId)
-					}
-				}
-			}
+			decision, err := processor.HandleMessage(message, time.Now().UTC(), false)
+			capture.Record(message, decision, err)
 		}
 	}()
 
@@ -217,7 +89,7 @@ func initWebsocketClient(conf *Config) error {
 	for {
 		select {
 		case <-done:
-			return nil
+			return errors.New("websocket connection lost")
 		case t := <-ticker.C:
 			err := c.WriteMessage(websocket.TextMessage, []byte(t.String()))
 			if err != nil {
@@ -243,91 +115,380 @@ func initWebsocketClient(conf *Config) error {
 	}
 }
 
-func CheckFilters(conf *Config, cg *ConfigGroup, env *SignalEnvelope, isFilterMessage bool) (msg string, err error) {
+// Processor routes inbound messages to the forwarding receivers
+type Processor struct {
+	conf         atomic.Pointer[Config]
+	confMu       sync.Mutex //serializes config updates
+	startedAt    time.Time
+	activity     *Activity
+	albums       *AlbumBatcher
+	digests      *Digester
+	directory    *Directory
+	archive      *Archive //nil when disabled
+	attachments  *AttachmentArchive
+	dedup        *AttachmentDedup
+	webhookFiles *WebhookFiles
+	alerts       *AlertTracker
+	stop         chan struct{} //closed to stop the background jobs
+
+	receiversMu sync.Mutex
+	unavailable map[string]string //receivers skipped by the membership check, with the reason
+}
+
+func NewProcessor(conf *Config) (*Processor, error) {
 	if conf == nil {
-		return "", errors.New("config is nil")
+		return nil, errors.New("config is nil")
 	}
-	if env == nil {
-		return "", errors.New("env is nil")
+
+	p := &Processor{
+		startedAt:    time.Now(),
+		activity:     new(Activity),
+		directory:    NewDirectory(),
+		attachments:  NewAttachmentArchive(),
+		dedup:        NewAttachmentDedup(),
+		webhookFiles: NewWebhookFiles(),
+		alerts:       NewAlertTracker(),
+		unavailable:  make(map[string]string),
 	}
+	p.conf.Store(conf)
+	p.albums = NewAlbumBatcher(p.dispatchAlbum)
 
-	findFn := func(Source string, Senders []string) bool {
-		for _, sn := range Senders {
-			if strings.EqualFold(Source, sn) {
-				return true
-			}
+	var err error
+	p.digests, err = NewDigester(conf.DigestStore(), p.dispatchDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	p.archive, err = OpenArchive(conf.Archive, conf.ArchivePath())
+	if err != nil {
+		return nil, fmt.Errorf("archive: %w", err)
+	}
+
+	//the group names must be resolved before routing, the directory is only informational otherwise
+	if err := p.RefreshDirectory(); err != nil {
+		if conf.UsesGroupNames() {
+			return nil, fmt.Errorf("group names resolution: %w", err)
 		}
+		Rlog.Error("directory refresh error: ", err)
+	}
 
-		return false
+	return p, nil
+}
+
+// Config returns the current config; it must not be modified, use UpdateConfig instead
+func (p *Processor) Config() *Config {
+	return p.conf.Load()
+}
+
+// UpdateConfig applies fn to a copy of the current config, validates it, saves it to the config file
+// and makes it current
+func (p *Processor) UpdateConfig(fn func(c *Config) error) (*Config, error) {
+	p.confMu.Lock()
+	defer p.confMu.Unlock()
+
+	conf, err := p.Config().Clone()
+	if err != nil {
+		return nil, err
 	}
+	if err := fn(conf); err != nil {
+		return nil, err
+	}
+	if err := p.CheckConfig(conf); err != nil {
+		return nil, err
+	}
+	if err := conf.Save(); err != nil {
+		return nil, err
+	}
+
+	p.conf.Store(conf)
+	p.digests.Start(conf.Forwarding)
+	Rlog.SetNumbersAllowed(conf.AllowNumbers)
+	Rlog.Info("config updated")
+
+	return conf, nil
+}
 
-	if len(cg.SenderNames) > 0 {
-		if !findFn(env.SourceName, cg.SenderNames) {
-			Rlog.Debugf("Sender name %s is not in Sender Names list config, ignoring message", env.SourceName)
-			return "", nil //nothing to do
+// CheckConfig validates the config and resolves its group names with the directory, the same as it is done
+// before the config is applied
+func (p *Processor) CheckConfig(conf *Config) error {
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if err := conf.ResolveGroupNames(p.directory); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	return nil
+}
+
+// Start runs the scheduled jobs, like digests and the directory refresh
+func (p *Processor) Start() {
+	p.stop = make(chan struct{})
+	p.digests.Start(p.Config().Forwarding)
+	go p.refreshDirectoryPeriodically()
+	if p.archive != nil {
+		go p.archive.purgePeriodically(p.stop)
+	}
+}
+
+// Close stops the scheduled jobs and sends everything still waiting in the album batches
+func (p *Processor) Close() {
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.digests.Stop()
+	p.albums.FlushAll()
+	if err := p.archive.Close(); err != nil {
+		Rlog.Error("archive close error: ", err)
+	}
+}
+
+// HandleMessage routes a single inbound frame and returns the decision taken for it;
+// in dry run nothing is sent, only the decision is made
+func (p *Processor) HandleMessage(message []byte, receivedAt time.Time, dryRun bool) (RoutingDecision, error) {
+	var msg SignalMessage
+
+	decision, err := p.route(message, &msg, receivedAt, dryRun)
+	if !dryRun {
+		activityErr := err
+		if decision.IsForward() {
+			activityErr = nil //send errors are recorded by Dispatch
+		}
+		sender := ""
+		if decision.Rule != nil {
+			sender = p.AuthorName(decision.Rule, &msg.Envelope) //the dashboard follows the rule's privacy settings
 		}
+		p.activity.Record(&msg.Envelope, sender, decision, activityErr)
+		p.archiveReceived(&msg.Envelope, decision)
+	}
+
+	return decision, err
+}
+
+// route decodes the frame into msg and routes it
+func (p *Processor) route(message []byte, msg *SignalMessage, receivedAt time.Time, dryRun bool) (RoutingDecision, error) {
+	conf := p.Config()
+
+	err := json.Unmarshal(message, msg)
+	if err != nil {
+		Rlog.Error("decode error: ", err.Error())
+		return Ignored("decode error"), err
+	}
+
+	now := uint64(receivedAt.UnixMilli())
+	if (now - msg.Envelope.Timestamp) > conf.IgnoreOlderMessages {
+		Rlog.Debugf("Now is %d, but message is from %d; diff is %d (>%d)", now, msg.Envelope.Timestamp, now-msg.Envelope.Timestamp, conf.IgnoreOlderMessages)
+		return Ignored("message is too old"), nil //this is sync message, will be ignored
 	}
 
-	if len(cg.SenderUUIDs) > 0 {
-		if !findFn(env.SourceUuid, cg.SenderUUIDs) {
-			Rlog.Debugf("Sender UUID %s is not in Sender UUIDs list config, ignoring message", env.SourceUuid)
-			return "", nil //nothing to do
+	if conf.IsPrintMessages && (len(msg.Envelope.DataMessage.Message) > 0 || len(msg.Envelope.DataMessage.Attachments) > 0) {
+		Rlog.Infof("Message: %s, Author: %s, Author UUID: %s, Attachments: %d, Group: %s",
+			msg.Envelope.DataMessage.Message,
+			msg.Envelope.Source,
+			msg.Envelope.SourceUuid,
+			len(msg.Envelope.DataMessage.Attachments),
+			p.directory.GroupName(msg.Envelope.DataMessage.GroupInfo.GroupId),
+		)
+	}
+
+	if isCommand(conf.Commands, &msg.Envelope) {
+		if dryRun {
+			return Ignored("admin command"), nil
 		}
+		return Ignored("admin command"), p.handleCommand(&msg.Envelope)
+	}
+
+	rec, err := GetForwardingRecord(conf, msg.Envelope.DataMessage.GroupInfo.GroupId)
+	if err != nil {
+		Rlog.Error("GetForwardingRecord:", err)
+		return Ignored("forwarding record lookup failed"), err
+	}
+	if rec == nil {
+		Rlog.Debugf("GroupId %s is not found in forwarding list, ignoring", msg.Envelope.DataMessage.GroupInfo.GroupId)
+		return Ignored("group is not in forwarding list"), nil
 	}
 
-	if !isFilterMessage {
-		return "ok", nil
+	Rlog.Debugf("recv: %s", message)
+
+	if !dryRun && len(msg.Envelope.DataMessage.Message) > 0 && len(msg.Envelope.DataMessage.Attachments) == 0 {
+		p.albums.Flush(albumKey(rec, &msg.Envelope)) //text message closes the album of its author
 	}
 
-	findStartWithFn := func(Msg string, Masks []string) bool {
-		for _, m := range Masks {
-			if strings.HasPrefix(Msg, m) {
-				return true
-			}
+	if !dryRun {
+		p.archiveAttachments(rec, &msg.Envelope) //regardless of the forwarding decision
+	}
+
+	rec.BotSpecialAddonMsg = p.expandTemplate(rec, rec.BotSpecialAddonMsg, &msg.Envelope) //rec is a copy
+	decision := EvaluateMessage(rec, &msg.Envelope)
+	if !decision.IsForward() {
+		Rlog.Debugf("message %d from group %s is %s", msg.Envelope.Timestamp, rec.Ref(), decision)
+		return decision, nil
+	}
+	if len(rec.Transforms) > 0 {
+		text, err := ApplyTransforms(rec, decision.Text)
+		if err != nil {
+			return Ignored("transform error"), err
 		}
+		if len(strings.TrimSpace(text)) == 0 && len(decision.Attachments) == 0 {
+			return Filtered(rec, "text is empty after transforms"), nil
+		}
+		decision.Text = text
+	}
+	if rec.AuthorDisplay.IsShown() && rec.ForwardingMode != FwModeDigest { //digest shows authors itself
+		decision.Text = withAuthor(p.AuthorName(rec, &msg.Envelope), decision.Text)
+	}
+	if dryRun {
+		return decision, nil
+	}
+	if !conf.IsSendingEnabled {
+		Rlog.Debug("sending messages disabled")
+		return Ignored("sending disabled"), nil
+	}
 
-		return false
+	if rec.ForwardingMode == FwModeDigest {
+		return p.queueDigest(decision, &msg.Envelope)
 	}
 
-	if len(cg.StartsWith) > 0 {
-		if !findStartWithFn(env.DataMessage.Message, cg.StartsWith) {
-			Rlog.Debugf("Message %s is not in starts with list config, ignoring message", env.DataMessage.Message)
-			if len(cg.Contains) == 0 { // return here only if we don't have contains config
-				return "", nil //nothing to do
-			}
+	if rec.AlbumWindowMs > 0 && len(decision.Attachments) > 0 {
+		p.albums.Add(decision, &msg.Envelope)
+		decision.Reason = "batched into album"
+		return decision, nil
+	}
+
+	return decision, p.Dispatch(decision, &msg.Envelope)
+}
+
+// queueDigest buffers the forward decision of a digest record, sending the digest when its count threshold is reached
+func (p *Processor) queueDigest(decision RoutingDecision, env *SignalEnvelope) (RoutingDecision, error) {
+	rec := decision.Rule
+
+	pending, err := p.digests.Add(rec.SourceGroupId(), DigestItem{
+		Timestamp:   env.Timestamp,
+		SourceName:  p.AuthorName(rec, env),
+		SourceUuid:  env.SourceUuid,
+		Text:        decision.Text,
+		Attachments: decision.Attachments,
+	})
+	if err != nil {
+		Rlog.Error("digest store save error: ", err)
+	}
+
+	decision.Reason = fmt.Sprintf("queued into digest (%d pending)", pending)
+	if rec.Digest.MaxItems > 0 && pending >= rec.Digest.MaxItems {
+		p.digests.Flush(rec.SourceGroupId())
+	}
+
+	return decision, nil
+}
+
+// Dispatch sends the forward decision to the receivers, the webhooks and the emails, each on its own, so a failed
+// destination doesn't stop the others; then marks the source envelopes as read and reacts to them, only when
+// something is forwarded
+func (p *Processor) Dispatch(decision RoutingDecision, envs ...*SignalEnvelope) error {
+	rec := decision.Rule
+
+	conf := p.Config()
+
+	receivers := p.availableReceivers(conf, decision.Receivers)
+	if len(receivers) == 0 && !rec.HasExternalReceivers() {
+		return fmt.Errorf("no available receivers for group %s", rec.Ref())
+	}
+
+	if !conf.IsSendingEnabled {
+		Rlog.Infof("sending messages disabled")
+		return nil //nothing is forwarded, the source messages are left unread
+	}
+
+	//the attachments are downloaded and processed once, every receiver, webhook and email gets the same content
+	downloaded, err := p.prepareAttachments(conf, rec, decision.Attachments)
+	if err != nil {
+		Rlog.Error("attachments error:", err)
+		p.activity.Error(rec.SourceGroupId(), fmt.Errorf("attachments: %w", err))
+		return err
+	}
+
+	var (
+		sent    int
+		sendErr error
+	)
+	if len(receivers) > 0 {
+		if sent, sendErr = p.forward(conf, rec, receivers, downloaded, decision.Text); sendErr != nil {
+			Rlog.Error("send message error:", sendErr)
 		} else {
-			return env.DataMessage.Message, nil
+			p.archiveSent(decision, receivers)
 		}
 	}
+	external := p.dispatchWebhooks(conf, decision, envs, downloaded)
+	external = p.dispatchEmail(conf, decision, envs, downloaded) || external
+	if sendErr != nil {
+		return sendErr //the source messages are reacted to only when all the receivers got them
+	}
+	if sent == 0 && !external {
+		Rlog.Debugf("nothing is forwarded from group %s", rec.Ref())
+		return nil
+	}
 
-	findContainsFn := func(Msg string, Masks []string) bool {
-		for _, m := range Masks {
-			if strings.Contains(Msg, m) {
-				return true
-			}
+	signal := NewSignalSink(conf)
+	for _, env := range envs {
+		ref := SignalMessageRef(env.Timestamp, env.Source)
+		if err := signal.MarkRead(ref); err != nil { //TODO: this doesn't has any effect (
+			Rlog.Error("mark message as read error:", err)
 		}
 
-		return false
+		if len(rec.ReactionMark) == 0 || !signal.Capabilities().React {
+			continue
+		}
+		if err := signal.React(env.DataMessage.GroupInfo.GroupId, ref, rec.ReactionMark); err != nil {
+			Rlog.Error("send message reaction error:", err)
+		}
 	}
 
-	if len(cg.Contains) > 0 {
-		if !findContainsFn(env.DataMessage.Message, cg.Contains) {
-			Rlog.Debugf("Message %s is not contains list config, ignoring message", env.DataMessage.Message)
-			return "", nil //nothing to do
+	return nil
+}
+
+// prepareAttachments downloads the attachments and applies the rule's image settings. When the metadata must be
+// stripped and the image can't be processed, the image is dropped, the original is never sent.
+func (p *Processor) prepareAttachments(conf *Config, rule *ConfigGroup, attachments []SignalAttachments) ([]DownloadedAttachment, error) {
+	downloaded, err := DownloadAttachments(conf, attachments)
+	if err != nil || rule.Images == nil {
+		return downloaded, err
+	}
+
+	watermark := strings.ReplaceAll(rule.Images.Watermark, "{group}", p.directory.GroupName(rule.SourceGroupId()))
+	processed := downloaded[:0]
+	for i := range downloaded {
+		if err := ProcessImage(rule.Images, &downloaded[i], watermark); err != nil {
+			if rule.Images.StripMetadata {
+				Rlog.Errorf("image %s processing error, dropping it: %v", downloaded[i].Id, err)
+				p.activity.Error(rule.SourceGroupId(), fmt.Errorf("image %s dropped, metadata is not stripped: %w", attachmentName(downloaded[i].SignalAttachments), err))
+				continue
+			}
+			Rlog.Errorf("image %s processing error, sending as is: %v", downloaded[i].Id, err)
 		}
+		processed = append(processed, downloaded[i])
+	}
+
+	return processed, nil
+}
+
+// forward sends the prepared attachments to every receiver in order, so the receivers get the messages
+// in the order they came; the repeated content is checked per receiver. It returns how many receivers got the message.
+func (p *Processor) forward(conf *Config, rule *ConfigGroup, receivers []string, downloaded []DownloadedAttachment, text string) (int, error) {
+	if rule.AttachmentDedupSec > 0 && len(downloaded) > 0 {
+		return p.sendDeduplicated(conf, rule, receivers, downloaded, text)
 	}
 
-	return env.DataMessage.Message, nil
+	return p.deliver(conf, rule.SourceGroupId(), receivers, text, downloaded)
 }
 
 func GetForwardingRecord(conf *Config, groupId string) (*ConfigGroup, error) {
 	for _, rep := range conf.Forwarding {
 		if !rep.IsEnabled {
-			Rlog.Debugf("record for group %s is disabled, ignoring", rep.GroupId)
+			Rlog.Debugf("record for group %s is disabled, ignoring", rep.Ref())
 			continue
 		}
 
-		if strings.EqualFold(rep.GroupId, groupId) {
+		if strings.EqualFold(rep.SourceGroupId(), groupId) {
 			return &rep, nil
 		}
 	}
@@ -335,16 +496,18 @@ func GetForwardingRecord(conf *Config, groupId string) (*ConfigGroup, error) {
 	return nil, nil
 }
 
-func SendMessage(conf *Config, recGroupIds []string, attachments []SignalAttachments, msgText string) error {
+// SendDownloaded sends the text with the already downloaded attachments to the groups,
+// returning the timestamp of the sent message (0 when nothing is sent)
+func SendDownloaded(conf *Config, recGroupIds []string, attachments []DownloadedAttachment, msgText string) (uint64, error) {
 	if conf == nil {
-		return errors.New("config is nil")
+		return 0, errors.New("config is nil")
 	}
 	if !conf.IsSendingEnabled {
 		Rlog.Infof("sending messages disabled")
-		return nil
+		return 0, nil
 	}
 	if len(attachments) == 0 && len(msgText) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	var msg SignalSendMessageV2
@@ -353,77 +516,183 @@ func SendMessage(conf *Config, recGroupIds []string, attachments []SignalAttachm
 	msg.Number = conf.SelfNumber
 
 	for _, rec := range recGroupIds {
-		r := rec
-		if !strings.HasPrefix(rec, "group.") {
-			r = fmt.Sprintf("group.%s", base64.StdEncoding.EncodeToString([]byte(rec)))
-		}
-		msg.Recipients = append(msg.Recipients, r)
+		msg.Recipients = append(msg.Recipients, GroupRecipient(rec))
 	}
 
 	msg.Mentions = make([]SignalMessageMentions, 0)
 	msg.QuoteMentions = make([]SignalMessageMentions, 0)
 	msg.Base64Attachments = make([]string, len(attachments))
+	for i, attachment := range attachments {
+		msg.Base64Attachments[i] = attachment.DataURI()
+	}
+
+	Rlog.Infof("SENDING MESSAGE TO %s", strings.Join(recGroupIds, ","))
+	response, err := postMessage(conf, &msg)
+	if err != nil {
+		return 0, err
+	}
 
+	return sentTimestamp(response), nil
+}
+
+// EditMessage replaces the text of the message sent earlier to the groups
+func EditMessage(conf *Config, recGroupIds []string, timestamp uint64, msgText string) error {
+	if conf == nil {
+		return errors.New("config is nil")
+	}
+	if !conf.IsSendingEnabled {
+		Rlog.Infof("sending messages disabled")
+		return nil
+	}
+
+	msg := SignalSendMessageV2{
+		Message:       msgText,
+		Number:        conf.SelfNumber,
+		EditTimestamp: timestamp,
+	}
+	for _, rec := range recGroupIds {
+		msg.Recipients = append(msg.Recipients, GroupRecipient(rec))
+	}
+
+	Rlog.Infof("EDITING MESSAGE %d IN %s", timestamp, strings.Join(recGroupIds, ","))
+	_, err := postMessage(conf, &msg)
+
+	return err
+}
+
+// sentTimestamp reads the timestamp of the sent message from the /v2/send response
+func sentTimestamp(response map[string]any) uint64 {
+	switch ts := response["timestamp"].(type) {
+	case string:
+		v, _ := strconv.ParseUint(ts, 10, 64)
+		return v
+	case float64:
+		return uint64(ts)
+	}
+
+	return 0
+}
+
+// DownloadedAttachment is an attachment fetched from signal-cli, with the SHA-256 of the original content
+type DownloadedAttachment struct {
+	SignalAttachments
+	Content []byte
+	Sha256  string
+}
+
+// DataURI is the attachment in the /v2/send form
+func (a *DownloadedAttachment) DataURI() string {
+	return fmt.Sprintf("data:%s;filename=%s;base64,%s", a.ContentType, a.Filename, base64.StdEncoding.EncodeToString(a.Content))
+}
+
+func DownloadAttachments(conf *Config, attachments []SignalAttachments) ([]DownloadedAttachment, error) {
+	downloaded := make([]DownloadedAttachment, len(attachments))
 	for i, attachment := range attachments {
-		response, err := http.Get(fmt.Sprintf("http://%s/v1/attachments/%s", conf.CLIAddress, attachment.Id))
-		if err != nil {
-			Rlog.Error("attachment error: ", err.Error())
-			return err
+		var err error
+		if downloaded[i], err = DownloadAttachment(conf, attachment); err != nil {
+			return nil, err
 		}
-		pr, pw := io.Pipe()
-		encoder := base64.NewEncoder(base64.StdEncoding, pw)
-		go func() {
-			_, err := io.Copy(encoder, response.Body)
-			encoder.Close()
+	}
 
-			if err != nil {
-				pw.CloseWithError(err)
-			} else {
-				pw.Close()
-			}
-		}()
-		out, err := io.ReadAll(pr)
-		if err != nil {
-			Rlog.Error("read error: ", err.Error())
-			return err
-		}
+	return downloaded, nil
+}
+
+// DownloadAttachment fetches the attachment, hashing the content while streaming it
+func DownloadAttachment(conf *Config, attachment SignalAttachments) (DownloadedAttachment, error) {
+	body, err := GetAttachment(conf, attachment.Id)
+	if err != nil {
+		Rlog.Error("attachment error: ", err.Error())
+		return DownloadedAttachment{}, err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	content, err := io.ReadAll(io.TeeReader(body, hash))
+	if err != nil {
+		Rlog.Error("read error: ", err.Error())
+		return DownloadedAttachment{}, err
+	}
+
+	return DownloadedAttachment{SignalAttachments: attachment, Content: content, Sha256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// GetAttachment streams the attachment's content from signal-cli, the caller closes it
+func GetAttachment(conf *Config, id string) (io.ReadCloser, error) {
+	response, err := http.Get(fmt.Sprintf("http://%s/v1/attachments/%s", conf.CLIAddress, url.PathEscape(id)))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("attachment %s bad status: %s", id, response.Status)
+	}
 
-		msg.Base64Attachments[i] = fmt.Sprintf("data:%s;filename=%s;base64,%s", attachment.ContentType, attachment.Filename, string(out))
+	return response.Body, nil
+}
+
+// GroupRecipient converts the group id to the recipient form expected by /v2/send
+func GroupRecipient(groupId string) string {
+	if strings.HasPrefix(groupId, "group.") {
+		return groupId
+	}
+
+	return fmt.Sprintf("group.%s", base64.StdEncoding.EncodeToString([]byte(groupId)))
+}
+
+// SendReply sends the text to the recipients as is (numbers, uuids or "group." ids), even when sending is disabled.
+// It is used for the bot's own messages, like command replies.
+func SendReply(conf *Config, recipients []string, text string) error {
+	if conf == nil {
+		return errors.New("config is nil")
 	}
 
+	msg := SignalSendMessageV2{
+		Message:    text,
+		Number:     conf.SelfNumber,
+		Recipients: recipients,
+	}
+
+	Rlog.Debugf("sending reply to %s", strings.Join(recipients, ","))
+	_, err := postMessage(conf, &msg)
+
+	return err
+}
+
+// postMessage sends the message via /v2/send and returns the decoded response
+func postMessage(conf *Config, msg *SignalSendMessageV2) (map[string]any, error) {
 	resp, err := json.Marshal(msg)
 	if err != nil {
 		Rlog.Error("json marshal err: ", err)
-		return err
+		return nil, err
 	}
 
-	//log.Println("message data: ", string(resp))
-
 	r, err := http.NewRequest("POST", fmt.Sprintf("http://%s/v2/send", conf.CLIAddress), bytes.NewBuffer(resp))
 	if err != nil {
 		Rlog.Error("new request err: ", err)
-		return err
+		return nil, err
 	}
 
 	r.Header.Add("Content-Type", "application/json")
-	Rlog.Infof("SENDING MESSAGE TO %s", strings.Join(recGroupIds, ","))
 	client := &http.Client{}
 	res, err := client.Do(r)
-	defer res.Body.Close()
 	if err != nil {
 		Rlog.Error("client send request error: ", err)
-		return err
+		return nil, err
 	}
-	response := make(map[string]interface{})
+	defer res.Body.Close()
+	response := make(map[string]any)
 	err = json.NewDecoder(res.Body).Decode(&response)
 	if err != nil {
 		Rlog.Error("client send request resp decode error: ", err)
-		return err
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		return response, fmt.Errorf("send bad status %d: %v", res.StatusCode, response["error"])
 	}
 
 	Rlog.Info("Message sent: ", response)
 
-	return nil
+	return response, nil
 }
 
 func MarkMessageAsRead(conf *Config, recipient string, timestamp uint64) error {
@@ -447,11 +716,11 @@ func MarkMessageAsRead(conf *Config, recipient string, timestamp uint64) error {
 	Rlog.Infof("MARKING MESSAGE %d AS READ", timestamp)
 	client := &http.Client{}
 	res, err := client.Do(r)
-	defer res.Body.Close()
 	if err != nil {
 		Rlog.Error("client send request error: ", err)
 		return err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusNoContent {
 		return errors.New("receipt bad status")
 	}
@@ -483,11 +752,11 @@ func SendMessageReaction(conf *Config, reactionMark string, recipient string, ta
 	Rlog.Infof("MARKING MESSAGE %d WITH REACTION %s", timestamp, reactionMark)
 	client := &http.Client{}
 	res, err := client.Do(r)
-	defer res.Body.Close()
 	if err != nil {
 		Rlog.Error("client send request error: ", err)
 		return err
 	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusNoContent {
 		return errors.New("receipt bad status")
 	}
@@ -495,40 +764,53 @@ func SendMessageReaction(conf *Config, reactionMark string, recipient string, ta
 }
 
 func GetGroupsList(conf *Config) ([]SignalGroupEntry, error) {
-	response, err := http.Get(fmt.Sprintf("http://%s/v1/groups/%s", conf.CLIAddress, conf.SelfNumber))
+	var groups []SignalGroupEntry
+	err := getList(fmt.Sprintf("http://%s/v1/groups/%s", conf.CLIAddress, conf.SelfNumber), &groups)
 	if err != nil {
 		Rlog.Error("groups list error: ", err.Error())
 		return nil, err
 	}
 
-	body, err := io.ReadAll(response.Body)
+	return groups, nil
+}
 
+func GetContactsList(conf *Config) ([]SignalContact, error) {
+	var contacts []SignalContact
+	err := getList(fmt.Sprintf("http://%s/v1/contacts/%s", conf.CLIAddress, conf.SelfNumber), &contacts)
 	if err != nil {
-		Rlog.Error("groups io read all error: ", err.Error())
+		Rlog.Error("contacts list error: ", err.Error())
 		return nil, err
 	}
 
+	return contacts, nil
+}
+
+// getList fetches a signal-cli list endpoint into v, turning the error responses into errors
+func getList(url string, v any) error {
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
 	if response.StatusCode != 200 {
 		resp := make(map[string]string)
 		err = json.Unmarshal(body, &resp)
 		if err != nil {
-			Rlog.Error("err response json unmarshal error: ", err.Error())
-			return nil, err
+			return fmt.Errorf("err response json unmarshal error: %w", err)
 		}
 		e := ""
 		for k, v := range resp {
 			e = fmt.Sprintf("%s: %s", k, v)
 		}
 
-		return nil, errors.New(e)
+		return errors.New(e)
 	}
 
-	var groups []SignalGroupEntry
-	err = json.Unmarshal(body, &groups)
-	if err != nil {
-		Rlog.Error("groups json unmarshal error: ", err.Error())
-		return nil, err
-	}
-
-	return groups, nil
+	return json.Unmarshal(body, v)
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultSendRateLimit = 30 //messages per minute
+
+// RateLimiter is a token bucket refilled at the rate per minute, holding up to a minute of tokens
+type RateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Allow takes a token when there is one, otherwise tells how long to wait for it.
+// The rate is passed on every call, so it follows the config changes.
+func (l *RateLimiter) Allow(perMinute int, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(perMinute)
+	if l.last.IsZero() {
+		l.tokens = capacity
+	} else {
+		l.tokens = min(capacity, l.tokens+now.Sub(l.last).Minutes()*capacity)
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - l.tokens) / capacity * float64(time.Minute))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ReceiverStatus struct {
+	GroupId string `json:"group_id"`
+	Name    string `json:"name,omitempty"`
+	Problem string `json:"problem"`
+}
+
+// availableReceivers returns the receivers the bot can send to, according to the directory, alerting when
+// a Signal receiver becomes unavailable or available again
+func (p *Processor) availableReceivers(conf *Config, receivers []string) []string {
+	available, notices := p.checkReceivers(conf, receivers)
+
+	//the notices are sent without the lock, a slow signal-cli must not hold the other dispatches
+	for _, notice := range notices {
+		p.alert(conf, notice)
+	}
+
+	return available
+}
+
+// checkReceivers returns the available receivers and the notices on their availability changes
+func (p *Processor) checkReceivers(conf *Config, receivers []string) ([]string, []string) {
+	p.receiversMu.Lock()
+	defer p.receiversMu.Unlock()
+
+	available := make([]string, 0, len(receivers))
+	var notices []string
+	for _, id := range receivers {
+		if !IsSignalReceiver(id) {
+			available = append(available, id) //other networks are not in the directory
+			continue
+		}
+
+		problem := p.directory.ReceiverProblem(id, conf.SelfNumber)
+		previous, wasUnavailable := p.unavailable[id]
+
+		switch {
+		case len(problem) == 0:
+			available = append(available, id)
+			if wasUnavailable {
+				delete(p.unavailable, id)
+				notices = append(notices, fmt.Sprintf("receiver %s is available again", p.directory.GroupName(id)))
+			}
+		case !wasUnavailable || previous != problem:
+			p.unavailable[id] = problem
+			p.activity.Error(id, fmt.Errorf("receiver is skipped: %s", problem))
+			notices = append(notices, fmt.Sprintf("receiver %s is skipped: %s", p.directory.GroupName(id), problem))
+		}
+	}
+
+	return available, notices
+}
+
+// ReceiversStatus checks all the receivers of the enabled rules and returns those the bot can't send to
+func (p *Processor) ReceiversStatus() []ReceiverStatus {
+	conf := p.Config()
+
+	seen := make(map[string]bool)
+	statuses := make([]ReceiverStatus, 0)
+	for _, rec := range conf.Forwarding {
+		if !rec.IsEnabled {
+			continue
+		}
+		for _, id := range rec.AllReceivers() {
+			if seen[id] || !IsSignalReceiver(id) {
+				continue
+			}
+			seen[id] = true
+
+			if problem := p.directory.ReceiverProblem(id, conf.SelfNumber); len(problem) > 0 {
+				statuses = append(statuses, ReceiverStatus{GroupId: id, Name: p.directory.GroupName(id), Problem: problem})
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].GroupId < statuses[j].GroupId })
+
+	return statuses
+}
+
+// alert logs the problem and reports it to the admin group, when commands are configured with one
+func (p *Processor) alert(conf *Config, text string) {
+	Rlog.Errorf("alert: %s", text)
+
+	if conf.Commands == nil || !conf.Commands.IsEnabled || len(strings.TrimSpace(conf.Commands.AdminGroupId)) == 0 {
+		return
+	}
+	if err := SendReply(conf, []string{GroupRecipient(conf.Commands.AdminGroupId)}, "⚠️ "+text); err != nil {
+		Rlog.Error("alert send error: ", err)
+	}
+}
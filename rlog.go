@@ -1,12 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 type RLog struct {
 	IsDebugEnabled bool
+	numbersAllowed atomic.Bool //phone numbers are masked in every message unless allowed, changed with the config
 }
 
 var Rlog = new(RLog)
@@ -15,12 +18,28 @@ func (l *RLog) SetDebugEnabled(enabled bool) {
 	l.IsDebugEnabled = enabled
 }
 
+func (l *RLog) SetNumbersAllowed(allowed bool) {
+	l.numbersAllowed.Store(allowed)
+}
+
+func (l *RLog) NumbersAllowed() bool {
+	return l.numbersAllowed.Load()
+}
+
+func (l *RLog) redact(s string) string {
+	if l.NumbersAllowed() {
+		return s
+	}
+
+	return RedactNumbers(s)
+}
+
 func (l *RLog) Debug(v ...any) {
 	if !l.IsDebugEnabled {
 		return
 	}
 	log.SetOutput(os.Stdout)
-	log.Println(v)
+	log.Print(l.redact(fmt.Sprintln(v...)))
 }
 
 func (l *RLog) Debugf(format string, v ...any) {
@@ -28,35 +47,35 @@ func (l *RLog) Debugf(format string, v ...any) {
 		return
 	}
 	log.SetOutput(os.Stdout)
-	log.Printf(format, v...)
+	log.Print(l.redact(fmt.Sprintf(format, v...)))
 }
 
 func (l *RLog) Info(v ...any) {
 	log.SetOutput(os.Stdout)
-	log.Println(v)
+	log.Print(l.redact(fmt.Sprintln(v...)))
 }
 
 func (l *RLog) Infof(format string, v ...any) {
 	log.SetOutput(os.Stdout)
-	log.Printf(format, v...)
+	log.Print(l.redact(fmt.Sprintf(format, v...)))
 }
 
 func (l *RLog) Error(v ...any) {
 	log.SetOutput(os.Stderr)
-	log.Println(v)
+	log.Print(l.redact(fmt.Sprintln(v...)))
 }
 
 func (l *RLog) Errorf(format string, v ...any) {
 	log.SetOutput(os.Stderr)
-	log.Printf(format, v...)
+	log.Print(l.redact(fmt.Sprintf(format, v...)))
 }
 
 func (l *RLog) Fatal(v ...any) {
 	log.SetOutput(os.Stderr)
-	log.Fatal(v)
+	log.Fatal(l.redact(fmt.Sprint(v...)))
 }
 
 func (l *RLog) Fatalf(format string, v ...any) {
 	log.SetOutput(os.Stderr)
-	log.Fatalf(format, v...)
+	log.Fatal(l.redact(fmt.Sprintf(format, v...)))
 }
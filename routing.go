@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	RouteAction string
+
+	// RoutingDecision is the outcome of evaluating an inbound message against a forwarding record.
+	// Only RouteForward decisions are sent, marked as read and reacted to.
+	RoutingDecision struct {
+		Action      RouteAction
+		Reason      string //why the message was filtered or ignored, or a note on how it is forwarded
+		Rule        *ConfigGroup
+		Text        string              //outbound text, when forwarding
+		Attachments []SignalAttachments //outbound attachments, when forwarding
+		Receivers   []string            //receivers group ids, the rule's or its matched routes', when forwarding
+	}
+)
+
+const (
+	RouteForward  RouteAction = "forward"
+	RouteFiltered RouteAction = "filtered" //matched a record, but rejected by its filters
+	RouteIgnored  RouteAction = "ignored"  //not applicable: unknown group, too old, wrong content for the mode, etc.
+)
+
+func Forward(rule *ConfigGroup, text string, attachments []SignalAttachments) RoutingDecision {
+	return RoutingDecision{Action: RouteForward, Rule: rule, Text: text, Attachments: attachments, Receivers: rule.Receivers()}
+}
+
+func Filtered(rule *ConfigGroup, format string, v ...any) RoutingDecision {
+	return RoutingDecision{Action: RouteFiltered, Rule: rule, Reason: fmt.Sprintf(format, v...)}
+}
+
+func Ignored(format string, v ...any) RoutingDecision {
+	return RoutingDecision{Action: RouteIgnored, Reason: fmt.Sprintf(format, v...)}
+}
+
+func (d RoutingDecision) IsForward() bool {
+	return d.Action == RouteForward
+}
+
+func (d RoutingDecision) String() string {
+	if d.Action == RouteForward && d.Rule != nil {
+		s := fmt.Sprintf("%s to %s (attachments: %d)", d.Action, strings.Join(d.Receivers, ","), len(d.Attachments))
+		if len(d.Reason) > 0 {
+			s = fmt.Sprintf("%s, %s", s, d.Reason)
+		}
+		return s
+	}
+
+	return fmt.Sprintf("%s: %s", d.Action, d.Reason)
+}
+
+// EvaluateMessage decides what to do with the envelope according to the record's forwarding mode.
+//
+// The sender filters (sender_names, sender_uuids) are applied in every mode. Then:
+//   - "attachments" forwards only messages with attachments, with bot_special_addon_msg as the text;
+//   - "messages" forwards only text messages without attachments, which must pass the text filters
+//     (starts_with, contains);
+//   - "all" forwards both the text and the attachments of any non-empty message;
+//   - "digest" is "all" collected into a periodic digest, with text only messages passing the text filters.
+//
+// With filter_attachments, messages with attachments in "attachments" and "all" modes must pass the text
+// filters too (see FilterAttachments). With forward_original_text, "attachments" mode sends the body text
+// or the captions followed by bot_special_addon_msg.
+//
+// The forwarded message goes to the receivers of the matching routes (see SelectRoutes), or to the rule's
+// receivers (and the external ones only, like webhooks) when no route matches.
+func EvaluateMessage(rule *ConfigGroup, env *SignalEnvelope) RoutingDecision {
+	decision := evaluateMode(rule, env)
+	if !decision.IsForward() || len(rule.Routes) == 0 {
+		return decision
+	}
+
+	receivers, names := SelectRoutes(rule, env)
+	if len(names) == 0 {
+		if len(decision.Receivers) == 0 && !rule.HasExternalReceivers() {
+			return Filtered(rule, "no route matches")
+		}
+		return decision
+	}
+
+	decision.Receivers = receivers
+	decision.Reason = "routes: " + strings.Join(names, ", ")
+
+	return decision
+}
+
+// SelectRoutes returns the receivers and the names of the rule's routes matching the envelope, in order,
+// up to the first matching route with stop_on_match
+func SelectRoutes(rule *ConfigGroup, env *SignalEnvelope) ([]string, []string) {
+	var receivers, names []string
+	for i := range rule.Routes {
+		route := &rule.Routes[i]
+		if !route.Match(env) {
+			continue
+		}
+
+		receivers = appendUnique(receivers, route.Receivers()...)
+		name := route.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		names = append(names, name)
+
+		if route.StopOnMatch {
+			break
+		}
+	}
+
+	return receivers, names
+}
+
+// Match checks the envelope against the route's filters: every given filter must pass; starts_with and contains
+// are checked against the body text and the attachments captions, and pass when either list matches
+func (rc *RouteConfig) Match(env *SignalEnvelope) bool {
+	dm := &env.DataMessage
+
+	if len(rc.SenderUUIDs) > 0 && !containsFold(rc.SenderUUIDs, env.SourceUuid) {
+		return false
+	}
+	if rc.HasAttachments != nil && *rc.HasAttachments != (len(dm.Attachments) > 0) {
+		return false
+	}
+
+	if len(rc.ContentTypes) > 0 {
+		matched := false
+		for _, a := range dm.Attachments {
+			for _, ct := range rc.ContentTypes {
+				matched = matched || strings.HasPrefix(strings.ToLower(a.ContentType), strings.ToLower(ct))
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(rc.StartsWith) > 0 || len(rc.Contains) > 0 {
+		texts := []string{dm.Message}
+		for _, a := range dm.Attachments {
+			texts = append(texts, a.Caption)
+		}
+		for _, text := range texts {
+			if matchText(rc.StartsWith, rc.Contains, text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func evaluateMode(rule *ConfigGroup, env *SignalEnvelope) RoutingDecision {
+	if rule == nil {
+		return Ignored("no forwarding record")
+	}
+	if env == nil {
+		return Ignored("empty envelope")
+	}
+
+	dm := &env.DataMessage
+	hasText := len(dm.Message) > 0
+	hasAttachments := len(dm.Attachments) > 0
+
+	switch rule.ForwardingMode {
+	case FwModeAttachments:
+		if !hasAttachments {
+			return Ignored("message has no attachments")
+		}
+	case FwModeMessages:
+		if !hasText || hasAttachments {
+			return Ignored("not a text only message")
+		}
+	case FwModeAll, FwModeDigest:
+		if !hasText && !hasAttachments {
+			return Ignored("empty message")
+		}
+	default:
+		return Ignored("unknown forwarding mode %s", rule.ForwardingMode)
+	}
+
+	if ok, reason := MatchSender(rule, env); !ok {
+		return Filtered(rule, "%s", reason)
+	}
+
+	attachments := dm.Attachments
+	if hasAttachments && rule.FilterAttachments {
+		var reason string
+		if attachments, reason = FilterAttachments(rule, dm.Message, dm.Attachments); len(attachments) == 0 {
+			return Filtered(rule, "%s", reason)
+		}
+	}
+
+	switch rule.ForwardingMode {
+	case FwModeAttachments:
+		if rule.ForwardOriginalText {
+			return Forward(rule, attachmentsText(rule, dm.Message, attachments), attachments)
+		}
+		return Forward(rule, rule.BotSpecialAddonMsg, attachments)
+	case FwModeMessages:
+		if ok, reason := MatchText(rule, dm.Message); !ok {
+			return Filtered(rule, "%s", reason)
+		}
+		return Forward(rule, dm.Message, nil)
+	case FwModeDigest:
+		if !hasAttachments {
+			if ok, reason := MatchText(rule, dm.Message); !ok {
+				return Filtered(rule, "%s", reason)
+			}
+		}
+		return Forward(rule, dm.Message, attachments)
+	default:
+		return Forward(rule, dm.Message, attachments)
+	}
+}
+
+// FilterAttachments returns the attachments passing the text filters: all of them when the body text
+// matches, otherwise only those with a matching caption
+func FilterAttachments(rule *ConfigGroup, body string, attachments []SignalAttachments) ([]SignalAttachments, string) {
+	if ok, _ := MatchText(rule, body); ok {
+		return attachments, ""
+	}
+
+	matched := make([]SignalAttachments, 0, len(attachments))
+	for _, a := range attachments {
+		if len(a.Caption) == 0 {
+			continue
+		}
+		if ok, _ := MatchText(rule, a.Caption); ok {
+			matched = append(matched, a)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, "neither text nor attachment captions match starts with or contains list"
+	}
+
+	return matched, ""
+}
+
+// attachmentsText is the outbound text for attachments: the body or, if it is empty, the captions,
+// with bot_special_addon_msg appended
+func attachmentsText(rule *ConfigGroup, body string, attachments []SignalAttachments) string {
+	parts := make([]string, 0, len(attachments)+1)
+	if len(strings.TrimSpace(body)) > 0 {
+		parts = append(parts, body)
+	} else {
+		for _, a := range attachments {
+			if len(strings.TrimSpace(a.Caption)) > 0 {
+				parts = append(parts, a.Caption)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return rule.BotSpecialAddonMsg
+	}
+
+	if len(strings.TrimSpace(rule.BotSpecialAddonMsg)) > 0 {
+		parts = append(parts, rule.BotSpecialAddonMsg)
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+// MatchSender checks the envelope's author against the record's sender_names and sender_uuids lists
+func MatchSender(rule *ConfigGroup, env *SignalEnvelope) (bool, string) {
+	findFn := func(source string, senders []string) bool {
+		for _, sn := range senders {
+			if strings.EqualFold(source, sn) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(rule.SenderNames) > 0 && !findFn(env.SourceName, rule.SenderNames) {
+		return false, fmt.Sprintf("sender name %s is not in sender names list", env.SourceName)
+	}
+
+	if len(rule.SenderUUIDs) > 0 && !findFn(env.SourceUuid, rule.SenderUUIDs) {
+		return false, fmt.Sprintf("sender UUID %s is not in sender UUIDs list", env.SourceUuid)
+	}
+
+	return true, ""
+}
+
+// MatchText checks the text against the record's starts_with and contains lists.
+// The text passes when either list matches; an empty list is not taken into account.
+func MatchText(rule *ConfigGroup, text string) (bool, string) {
+	if matchText(rule.StartsWith, rule.Contains, text) {
+		return true, ""
+	}
+
+	return false, "text matches neither starts with nor contains list"
+}
+
+func matchText(startsWith, contains []string, text string) bool {
+	if len(startsWith) == 0 && len(contains) == 0 {
+		return true
+	}
+
+	for _, m := range startsWith {
+		if strings.HasPrefix(text, m) {
+			return true
+		}
+	}
+
+	for _, m := range contains {
+		if strings.Contains(text, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func envelope(text string, attachments ...SignalAttachments) *SignalEnvelope {
+	return &SignalEnvelope{
+		SourceName: "Alice",
+		SourceUuid: "uuid-alice",
+		DataMessage: SignalDataMessage{
+			Message:     text,
+			Attachments: attachments,
+		},
+	}
+}
+
+func TestEvaluateMessage(t *testing.T) {
+	photo := SignalAttachments{Id: "a1", ContentType: "image/jpeg"}
+	captioned := SignalAttachments{Id: "a2", ContentType: "image/jpeg", Caption: "#news photo"}
+
+	tests := []struct {
+		name        string
+		rule        ConfigGroup
+		env         *SignalEnvelope
+		action      RouteAction
+		reason      string //part of the reason, when not forwarded
+		text        string
+		attachments int
+	}{
+		{
+			name:   "attachments mode ignores text",
+			rule:   ConfigGroup{ForwardingMode: FwModeAttachments},
+			env:    envelope("hello"),
+			action: RouteIgnored,
+			reason: "no attachments",
+		},
+		{
+			name:        "attachments mode sends the addon text",
+			rule:        ConfigGroup{ForwardingMode: FwModeAttachments, BotSpecialAddonMsg: "via bot"},
+			env:         envelope("hello", photo),
+			action:      RouteForward,
+			text:        "via bot",
+			attachments: 1,
+		},
+		{
+			name:        "attachments mode with the original text",
+			rule:        ConfigGroup{ForwardingMode: FwModeAttachments, BotSpecialAddonMsg: "via bot", ForwardOriginalText: true},
+			env:         envelope("", captioned),
+			action:      RouteForward,
+			text:        "#news photo\nvia bot",
+			attachments: 1,
+		},
+		{
+			name:   "messages mode ignores attachments",
+			rule:   ConfigGroup{ForwardingMode: FwModeMessages},
+			env:    envelope("hello", photo),
+			action: RouteIgnored,
+			reason: "not a text only message",
+		},
+		{
+			name:   "messages mode filters text",
+			rule:   ConfigGroup{ForwardingMode: FwModeMessages, StartsWith: []string{"#news"}},
+			env:    envelope("hello"),
+			action: RouteFiltered,
+			reason: "neither starts with nor contains",
+		},
+		{
+			name:   "messages mode passes contains",
+			rule:   ConfigGroup{ForwardingMode: FwModeMessages, StartsWith: []string{"#news"}, Contains: []string{"urgent"}},
+			env:    envelope("this is urgent"),
+			action: RouteForward,
+			text:   "this is urgent",
+		},
+		{
+			name:   "sender filter in every mode",
+			rule:   ConfigGroup{ForwardingMode: FwModeAll, SenderUUIDs: []string{"uuid-bob"}},
+			env:    envelope("hello"),
+			action: RouteFiltered,
+			reason: "sender UUID",
+		},
+		{
+			name:   "sender names are case insensitive",
+			rule:   ConfigGroup{ForwardingMode: FwModeAll, SenderNames: []string{"alice"}},
+			env:    envelope("hello"),
+			action: RouteForward,
+			text:   "hello",
+		},
+		{
+			name:   "all mode ignores empty messages",
+			rule:   ConfigGroup{ForwardingMode: FwModeAll},
+			env:    envelope(""),
+			action: RouteIgnored,
+			reason: "empty message",
+		},
+		{
+			name:        "all mode doesn't filter attachments by default",
+			rule:        ConfigGroup{ForwardingMode: FwModeAll, StartsWith: []string{"#news"}},
+			env:         envelope("hello", photo),
+			action:      RouteForward,
+			text:        "hello",
+			attachments: 1,
+		},
+		{
+			name:        "filter attachments keeps matching captions",
+			rule:        ConfigGroup{ForwardingMode: FwModeAll, StartsWith: []string{"#news"}, FilterAttachments: true},
+			env:         envelope("hello", photo, captioned),
+			action:      RouteForward,
+			text:        "hello",
+			attachments: 1,
+		},
+		{
+			name:   "filter attachments without matches",
+			rule:   ConfigGroup{ForwardingMode: FwModeAll, StartsWith: []string{"#news"}, FilterAttachments: true},
+			env:    envelope("hello", photo),
+			action: RouteFiltered,
+			reason: "attachment captions",
+		},
+		{
+			name:   "digest mode filters text only messages",
+			rule:   ConfigGroup{ForwardingMode: FwModeDigest, Contains: []string{"release"}},
+			env:    envelope("hello"),
+			action: RouteFiltered,
+			reason: "neither starts with nor contains",
+		},
+		{
+			name:        "digest mode takes attachments",
+			rule:        ConfigGroup{ForwardingMode: FwModeDigest, Contains: []string{"release"}},
+			env:         envelope("hello", photo),
+			action:      RouteForward,
+			text:        "hello",
+			attachments: 1,
+		},
+		{
+			name:   "unknown mode",
+			rule:   ConfigGroup{ForwardingMode: "everything"},
+			env:    envelope("hello"),
+			action: RouteIgnored,
+			reason: "unknown forwarding mode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateMessage(&tt.rule, tt.env)
+			if d.Action != tt.action {
+				t.Fatalf("action = %s (%s), want %s", d.Action, d.Reason, tt.action)
+			}
+			if !d.IsForward() {
+				if !strings.Contains(d.Reason, tt.reason) {
+					t.Errorf("reason = %q, want %q in it", d.Reason, tt.reason)
+				}
+				return
+			}
+			if d.Text != tt.text || len(d.Attachments) != tt.attachments {
+				t.Errorf("forward %q with %d attachments, want %q with %d", d.Text, len(d.Attachments), tt.text, tt.attachments)
+			}
+		})
+	}
+}
+
+func TestSelectRoutes(t *testing.T) {
+	hasAttachments := true
+	rule := &ConfigGroup{
+		ForwardingMode:    FwModeAll,
+		ReceiversGroupIds: []string{"default"},
+		Routes: []RouteConfig{
+			{Name: "alerts", StartsWith: []string{"!alert"}, ReceiversGroupIds: []string{"ops"}, StopOnMatch: true},
+			{Name: "photos", ContentTypes: []string{"image/"}, ReceiversGroupIds: []string{"media", "ops"}},
+			{ReceiversGroupIds: []string{"files"}, HasAttachments: &hasAttachments},
+			{Name: "bob", SenderUUIDs: []string{"UUID-BOB"}, Contains: []string{"release"}, ReceiversGroupIds: []string{"releases"}},
+		},
+	}
+	photo := SignalAttachments{ContentType: "image/png"}
+
+	tests := []struct {
+		name      string
+		env       *SignalEnvelope
+		receivers []string
+		routes    []string
+	}{
+		{name: "stop on match", env: envelope("!alert disk", photo), receivers: []string{"ops"}, routes: []string{"alerts"}},
+		{name: "every matching route", env: envelope("look", photo), receivers: []string{"media", "ops", "files"}, routes: []string{"photos", "#3"}},
+		{name: "caption matches", env: envelope("", SignalAttachments{ContentType: "application/pdf", Caption: "!alert report"}), receivers: []string{"ops"}, routes: []string{"alerts"}},
+		{name: "content type", env: envelope("", SignalAttachments{ContentType: "application/pdf"}), receivers: []string{"files"}, routes: []string{"#3"}},
+		{name: "no match", env: envelope("hello"), receivers: nil, routes: nil},
+		{name: "sender and text", env: &SignalEnvelope{SourceUuid: "uuid-bob", DataMessage: SignalDataMessage{Message: "new release"}}, receivers: []string{"releases"}, routes: []string{"bob"}},
+		{name: "other sender", env: envelope("new release"), receivers: nil, routes: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receivers, routes := SelectRoutes(rule, tt.env)
+			if strings.Join(receivers, ",") != strings.Join(tt.receivers, ",") || strings.Join(routes, ",") != strings.Join(tt.routes, ",") {
+				t.Errorf("got %v by %v, want %v by %v", receivers, routes, tt.receivers, tt.routes)
+			}
+		})
+	}
+}
+
+func TestEvaluateMessageRoutes(t *testing.T) {
+	route := RouteConfig{Name: "news", StartsWith: []string{"#news"}, ReceiversGroupIds: []string{"news"}}
+
+	tests := []struct {
+		name      string
+		rule      ConfigGroup
+		text      string
+		action    RouteAction
+		receivers []string
+	}{
+		{
+			name:      "matched route replaces the rule's receivers",
+			rule:      ConfigGroup{ForwardingMode: FwModeAll, ReceiversGroupIds: []string{"default"}, Routes: []RouteConfig{route}},
+			text:      "#news today",
+			action:    RouteForward,
+			receivers: []string{"news"},
+		},
+		{
+			name:      "rule's receivers are the fallback",
+			rule:      ConfigGroup{ForwardingMode: FwModeAll, ReceiversGroupIds: []string{"default"}, Routes: []RouteConfig{route}},
+			text:      "hello",
+			action:    RouteForward,
+			receivers: []string{"default"},
+		},
+		{
+			name:   "no fallback",
+			rule:   ConfigGroup{ForwardingMode: FwModeAll, Routes: []RouteConfig{route}},
+			text:   "hello",
+			action: RouteFiltered,
+		},
+		{
+			name:   "webhooks are the fallback",
+			rule:   ConfigGroup{ForwardingMode: FwModeAll, Routes: []RouteConfig{route}, Webhooks: []WebhookConfig{{URL: "https://example.org"}}},
+			text:   "hello",
+			action: RouteForward,
+		},
+		{
+			name:   "routes don't bypass the mode filters",
+			rule:   ConfigGroup{ForwardingMode: FwModeMessages, Contains: []string{"urgent"}, Routes: []RouteConfig{route}},
+			text:   "#news today",
+			action: RouteFiltered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := EvaluateMessage(&tt.rule, envelope(tt.text))
+			if d.Action != tt.action {
+				t.Fatalf("action = %s (%s), want %s", d.Action, d.Reason, tt.action)
+			}
+			if strings.Join(d.Receivers, ",") != strings.Join(tt.receivers, ",") {
+				t.Errorf("receivers = %v, want %v", d.Receivers, tt.receivers)
+			}
+		})
+	}
+}
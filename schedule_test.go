@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "@hourly"},
+		{spec: "@daily"},
+		{spec: "@weekly"},
+		{spec: "@every 90m"},
+		{spec: "*/15 9-17 * * 1-5"},
+		{spec: "0 8,20 1 */3 7"},
+		{spec: "@every 30s", wantErr: true},
+		{spec: "@every soon", wantErr: true},
+		{spec: "0 8 * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "0 24 * * *", wantErr: true},
+		{spec: "0 0 0 * *", wantErr: true},
+		{spec: "0 0 * 13 *", wantErr: true},
+		{spec: "0 0 * * 8", wantErr: true},
+		{spec: "5-1 * * * *", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "a * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if _, err := ParseSchedule(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	from := at("2024-05-01 10:07:30") //Wednesday
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{spec: "@hourly", from: from, want: at("2024-05-01 11:00:00")},
+		{spec: "@daily", from: from, want: at("2024-05-02 00:00:00")},
+		{spec: "@weekly", from: from, want: at("2024-05-05 00:00:00")},
+		{spec: "@every 2h", from: from, want: at("2024-05-01 12:07:30")},
+		{spec: "*/15 * * * *", from: from, want: at("2024-05-01 10:15:00")},
+		{spec: "* * * * *", from: at("2024-05-01 10:07:00"), want: at("2024-05-01 10:08:00")},
+		{spec: "30 9 * * *", from: from, want: at("2024-05-02 09:30:00")},
+		{spec: "0 9-17 * * 1-5", from: at("2024-05-03 18:00:00"), want: at("2024-05-06 09:00:00")},
+		{spec: "0 0 * * 7", from: from, want: at("2024-05-05 00:00:00")},
+		{spec: "0 0 1 */3 *", from: from, want: at("2024-07-01 00:00:00")},
+		{spec: "0 0 29 2 *", from: from, want: at("2028-02-29 00:00:00")},
+		{spec: "0 12 15 * 1", from: from, want: at("2024-05-06 12:00:00")}, //either day of month or day of week
+		{spec: "0 0 31 2 *", from: from, want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("parse error: %v", err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextHalfHourZone(t *testing.T) {
+	zone := time.FixedZone("IST", 5*3600+1800)
+	s, err := ParseSchedule("0 9 * * *")
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	got := s.Next(time.Date(2024, 5, 1, 8, 59, 0, 0, zone))
+	if want := time.Date(2024, 5, 1, 9, 0, 0, 0, zone); !got.Equal(want) {
+		t.Errorf("next = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SignalSink sends to the groups through signal-cli-rest-api, the message reference is its timestamp;
+// the reference of a message by someone else also holds its author, like "1700000000000:+123"
+type SignalSink struct {
+	conf *Config
+}
+
+func NewSignalSink(conf *Config) *SignalSink {
+	return &SignalSink{conf: conf}
+}
+
+// Capabilities: signal-cli-rest-api edits, remotely deletes and reacts to the messages in the groups
+func (s *SignalSink) Capabilities() SinkCapabilities {
+	return SinkCapabilities{Edit: true, Delete: true, React: true}
+}
+
+func (s *SignalSink) SendText(groupId string, text string) (MessageRef, error) {
+	return s.SendAttachments(groupId, text, nil)
+}
+
+func (s *SignalSink) SendAttachments(groupId string, text string, attachments []DownloadedAttachment) (MessageRef, error) {
+	timestamp, err := SendDownloaded(s.conf, []string{groupId}, attachments, text)
+	if err != nil || timestamp == 0 {
+		return "", err
+	}
+
+	return MessageRef(strconv.FormatUint(timestamp, 10)), nil
+}
+
+func (s *SignalSink) Edit(groupId string, ref MessageRef, text string) error {
+	timestamp, err := s.ownTimestamp(ref)
+	if err != nil {
+		return err
+	}
+
+	return EditMessage(s.conf, []string{groupId}, timestamp, text)
+}
+
+// Delete removes the message for everyone in the group (remote delete)
+func (s *SignalSink) Delete(groupId string, ref MessageRef) error {
+	timestamp, err := s.ownTimestamp(ref)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{"recipient": GroupRecipient(groupId), "timestamp": timestamp})
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest("DELETE", fmt.Sprintf("http://%s/v1/remote-delete/%s", s.conf.CLIAddress, s.conf.SelfNumber), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Add("Content-Type", "application/json")
+
+	Rlog.Infof("DELETING MESSAGE %d IN %s", timestamp, groupId)
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("remote delete bad status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// React reacts to the message in the group, the bot's own one or the author's of the reference
+func (s *SignalSink) React(groupId string, ref MessageRef, emoji string) error {
+	timestamp, author, err := s.parseRef(ref)
+	if err != nil {
+		return err
+	}
+
+	return SendMessageReaction(s.conf, emoji, GroupRecipient(groupId), author, timestamp)
+}
+
+// MarkRead sends the read receipt of the message to its author
+func (s *SignalSink) MarkRead(ref MessageRef) error {
+	timestamp, author, err := s.parseRef(ref)
+	if err != nil {
+		return err
+	}
+
+	return MarkMessageAsRead(s.conf, author, timestamp)
+}
+
+// SignalMessageRef is the reference of the received message, to react to it or mark it as read
+func SignalMessageRef(timestamp uint64, author string) MessageRef {
+	return MessageRef(fmt.Sprintf("%d:%s", timestamp, author))
+}
+
+// parseRef returns the message timestamp and author, the bot itself when the reference has no author
+func (s *SignalSink) parseRef(ref MessageRef) (uint64, string, error) {
+	value, author, _ := strings.Cut(string(ref), ":")
+	timestamp, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || timestamp == 0 {
+		return 0, "", fmt.Errorf("invalid signal message reference: %s", ref)
+	}
+	if len(author) == 0 {
+		author = s.conf.SelfNumber
+	}
+
+	return timestamp, author, nil
+}
+
+// ownTimestamp returns the timestamp of the bot's message, only those can be edited and deleted
+func (s *SignalSink) ownTimestamp(ref MessageRef) (uint64, error) {
+	timestamp, author, err := s.parseRef(ref)
+	if err != nil {
+		return 0, err
+	}
+	if author != s.conf.SelfNumber {
+		return 0, fmt.Errorf("signal message %d is not sent by the bot: %w", timestamp, ErrNotSupported)
+	}
+
+	return timestamp, nil
+}
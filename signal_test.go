@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignalSinkReactAndMarkRead(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 204, nil
+	})
+	conf := &Config{CLIAddress: strings.TrimPrefix(srv.URL, "http://"), SelfNumber: "+100"}
+	sink := NewSignalSink(conf)
+	ref := SignalMessageRef(1700000000000, "+200")
+
+	if err := sink.MarkRead(ref); err != nil {
+		t.Fatalf("mark read error: %v", err)
+	}
+	if err := sink.React("source", ref, "👀"); err != nil {
+		t.Fatalf("react error: %v", err)
+	}
+	if err := sink.React("receiver", "1700000000001", "👍"); err != nil {
+		t.Fatalf("own message react error: %v", err)
+	}
+
+	calls := srv.recorded()
+	if len(calls) != 3 {
+		t.Fatalf("calls = %d", len(calls))
+	}
+
+	receipt := jsonBody(t, calls[0])
+	if calls[0].Path != "/v1/receipts/+100" || receipt["recipient"] != "+200" || receipt["timestamp"] != float64(1700000000000) {
+		t.Errorf("receipt = %s %v", calls[0].Path, receipt)
+	}
+
+	reaction := jsonBody(t, calls[1])
+	if calls[1].Path != "/v1/reactions/+100" || reaction["recipient"] != GroupRecipient("source") || reaction["target_author"] != "+200" {
+		t.Errorf("reaction = %s %v", calls[1].Path, reaction)
+	}
+
+	if own := jsonBody(t, calls[2]); own["target_author"] != "+100" || own["timestamp"] != float64(1700000000001) {
+		t.Errorf("own message reaction = %v", own)
+	}
+}
+
+func TestSignalSinkEditsOnlyOwnMessages(t *testing.T) {
+	sink := NewSignalSink(&Config{SelfNumber: "+100"})
+
+	if err := sink.Edit("group", SignalMessageRef(1700000000000, "+200"), "text"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("edit error = %v, want not supported", err)
+	}
+	if err := sink.Delete("group", SignalMessageRef(1700000000000, "+200")); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("delete error = %v, want not supported", err)
+	}
+	if err := sink.React("group", "not a timestamp", "👍"); err == nil {
+		t.Error("invalid reference is accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DestSignal   = "signal"   //signal:<group id>, or the group id without the prefix
+	DestMatrix   = "matrix"   //matrix:!room:server or matrix:#alias:server
+	DestTelegram = "telegram" //telegram:<chat_id> or telegram:@channel
+
+	defaultSinkTimeout = 30 * time.Second
+)
+
+type (
+	// MessageRef identifies the sent message on its network: the Signal timestamp, the Matrix event id
+	// or the Telegram message id
+	MessageRef string
+
+	// SinkCapabilities tells what the network allows to do with the sent messages
+	SinkCapabilities struct {
+		Edit   bool
+		Delete bool
+		React  bool
+	}
+
+	// Sink delivers the messages to a chat network; dest is the receiver address without the network prefix,
+	// like the Signal group id or the Matrix room id.
+	// Webhooks and emails are not sinks: they are the rule's settings rather than the receiver addresses, get
+	// the message with its group and author in their own formats and have no sent message to edit. Dispatch
+	// delivers them in the background next to the sinks, a failed sink doesn't stop them.
+	Sink interface {
+		Capabilities() SinkCapabilities
+		SendText(dest string, text string) (MessageRef, error)
+		SendAttachments(dest string, text string, attachments []DownloadedAttachment) (MessageRef, error)
+		Edit(dest string, ref MessageRef, text string) error
+		Delete(dest string, ref MessageRef) error
+		React(dest string, ref MessageRef, emoji string) error
+	}
+)
+
+var ErrNotSupported = errors.New("not supported by the network")
+
+// newSink makes the receiver's sink, the tests replace it with fakes
+var newSink = NewSink
+
+// SplitDestination splits the typed receiver like "telegram:123" into the network and the address;
+// receivers without a known prefix are Signal group ids
+func SplitDestination(receiver string) (string, string) {
+	if kind, address, ok := strings.Cut(receiver, ":"); ok && (kind == DestSignal || kind == DestMatrix || kind == DestTelegram) {
+		return kind, address
+	}
+
+	return DestSignal, receiver
+}
+
+// IsSignalReceiver tells whether the receiver is a Signal group id, not another network's destination
+func IsSignalReceiver(receiver string) bool {
+	kind, _ := SplitDestination(receiver)
+
+	return kind == DestSignal
+}
+
+func NewSink(conf *Config, kind string) (Sink, error) {
+	switch kind {
+	case DestSignal:
+		return NewSignalSink(conf), nil
+	case DestMatrix:
+		if conf.Matrix == nil {
+			return nil, errors.New("matrix is not configured")
+		}
+		return NewMatrixSink(conf.Matrix), nil
+	case DestTelegram:
+		if conf.Telegram == nil {
+			return nil, errors.New("telegram is not configured")
+		}
+		return NewTelegramSink(conf.Telegram), nil
+	default:
+		return nil, fmt.Errorf("unknown destination type: %s", kind)
+	}
+}
+
+// normalizeDestinations trims the receivers and drops the optional signal: prefix, so Signal receivers
+// are the group ids known to the directory
+func normalizeDestinations(receivers []string) {
+	for i := range receivers {
+		receivers[i] = strings.TrimSpace(receivers[i])
+		if kind, address := SplitDestination(receivers[i]); kind == DestSignal {
+			receivers[i] = strings.TrimSpace(address)
+		}
+	}
+}
+
+// validateDestination checks the typed receiver against the configured networks
+func (c *Config) validateDestination(receiver string) error {
+	kind, address := SplitDestination(receiver)
+	switch kind {
+	case DestMatrix:
+		if c.Matrix == nil {
+			return fmt.Errorf("receiver %s requires matrix settings", receiver)
+		}
+		if (!strings.HasPrefix(address, "!") && !strings.HasPrefix(address, "#")) || !strings.Contains(address, ":") {
+			return fmt.Errorf("matrix receiver must be a room id or alias, like matrix:!room:server: %s", receiver)
+		}
+	case DestTelegram:
+		if c.Telegram == nil {
+			return fmt.Errorf("receiver %s requires telegram settings", receiver)
+		}
+		if len(strings.TrimSpace(address)) == 0 {
+			return fmt.Errorf("telegram receiver requires a chat id: %s", receiver)
+		}
+	}
+
+	return nil
+}
+
+// SendTo sends the text with the attachments to the typed receiver, returning the sent message reference
+// (empty when nothing is sent)
+func SendTo(conf *Config, receiver string, text string, attachments []DownloadedAttachment) (MessageRef, error) {
+	if !conf.IsSendingEnabled {
+		Rlog.Infof("sending messages disabled")
+		return "", nil
+	}
+	if len(attachments) == 0 && len(text) == 0 {
+		return "", nil
+	}
+
+	kind, address := SplitDestination(receiver)
+	sink, err := newSink(conf, kind)
+	if err != nil {
+		return "", err
+	}
+	if len(attachments) == 0 {
+		return sink.SendText(address, text)
+	}
+
+	return sink.SendAttachments(address, text, attachments)
+}
+
+// EditSent replaces the text of the message sent to the receiver, when its network allows edits
+func EditSent(conf *Config, receiver string, ref MessageRef, text string) error {
+	if !conf.IsSendingEnabled {
+		Rlog.Infof("sending messages disabled")
+		return nil
+	}
+
+	kind, address := SplitDestination(receiver)
+	sink, err := newSink(conf, kind)
+	if err != nil {
+		return err
+	}
+	if !sink.Capabilities().Edit {
+		return fmt.Errorf("%s edit: %w", kind, ErrNotSupported)
+	}
+
+	return sink.Edit(address, ref, text)
+}
+
+// deliver sends the message to every receiver, reporting the failed ones to the activity of the source group;
+// it returns how many receivers got the message
+func (p *Processor) deliver(conf *Config, groupId string, receivers []string, text string, attachments []DownloadedAttachment) (int, error) {
+	if len(text) == 0 && len(attachments) == 0 {
+		return 0, nil
+	}
+
+	sent := 0
+	var errs []error
+	for _, receiver := range receivers {
+		if _, err := SendTo(conf, receiver, text, attachments); err != nil {
+			Rlog.Errorf("send to %s error: %v", receiver, err)
+			p.activity.Error(groupId, fmt.Errorf("send to %s: %w", receiver, err))
+			errs = append(errs, fmt.Errorf("%s: %w", receiver, err))
+			continue
+		}
+		sent++
+	}
+
+	return sent, errors.Join(errs...)
+}
+
+// sinkRequest makes the http request with the JSON (or the given reader's) body and decodes the JSON response
+// into out; the response status is checked by the caller, so the API error details can be reported
+func sinkRequest(client *http.Client, method, u string, header http.Header, body any, out any) (int, error) {
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = b
+	default:
+		content, err := json.Marshal(b)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(content)
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Content-Type", "application/json")
+	}
+
+	r, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+
+	res, err := client.Do(r)
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil && res.StatusCode < 300 {
+		return res.StatusCode, fmt.Errorf("response decode error: %w", err)
+	}
+
+	return res.StatusCode, nil
+}
+
+// redactURLError drops the request url from the error, it may hold a token (like the Telegram bot token)
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+
+	return err
+}
+
+func sinkTimeout(timeoutSec int) time.Duration {
+	if timeoutSec > 0 {
+		return time.Duration(timeoutSec) * time.Second
+	}
+
+	return defaultSinkTimeout
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultTelegramAPIURL = "https://api.telegram.org"
+
+	telegramTextMax    = 4096 //message length limit, longer texts are split
+	telegramCaptionMax = 1024
+	telegramCaptionRef = "caption:" //reference prefix of the attachment sent with the text as its caption
+)
+
+type (
+	TelegramConfig struct {
+		BotToken   string `json:"bot_token"`
+		APIURL     string `json:"api_url,omitempty"` //https://api.telegram.org by default, or a local Bot API server
+		TimeoutSec int    `json:"timeout_sec,omitempty"`
+	}
+
+	// TelegramSink posts to the chats with the Bot API, the bot must be a member of the chats
+	TelegramSink struct {
+		conf   *TelegramConfig
+		client *http.Client
+	}
+
+	telegramResponse struct {
+		Ok          bool            `json:"ok"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"` //the sent message, or true
+	}
+)
+
+func (tc *TelegramConfig) Validate() error {
+	if tc == nil {
+		return nil
+	}
+
+	if len(tc.BotToken) == 0 {
+		return fmt.Errorf("telegram bot token is required")
+	}
+	if len(tc.APIURL) > 0 {
+		u, err := url.Parse(tc.APIURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("telegram api url must be an http(s) url")
+		}
+	}
+	if tc.TimeoutSec < 0 {
+		return fmt.Errorf("telegram timeout must not be negative")
+	}
+
+	return nil
+}
+
+func NewTelegramSink(tc *TelegramConfig) *TelegramSink {
+	return &TelegramSink{conf: tc, client: &http.Client{Timeout: sinkTimeout(tc.TimeoutSec)}}
+}
+
+// Capabilities: the bot edits the texts and the captions, deletes the messages (within 48 hours)
+// and reacts with the emoji of Telegram's reactions list
+func (s *TelegramSink) Capabilities() SinkCapabilities {
+	return SinkCapabilities{Edit: true, Delete: true, React: true}
+}
+
+// SendText posts the text split into the messages of the length limit, the reference is the first one
+func (s *TelegramSink) SendText(chatId string, text string) (MessageRef, error) {
+	var ref MessageRef
+	for _, chunk := range splitText(text, telegramTextMax) {
+		id, err := s.call("sendMessage", map[string]any{"chat_id": chatId, "text": chunk})
+		if err != nil {
+			return ref, err
+		}
+		if len(ref) == 0 {
+			ref = MessageRef(id)
+		}
+	}
+
+	return ref, nil
+}
+
+// SendAttachments posts the text, then the attachments; a short text becomes the caption of the first attachment
+func (s *TelegramSink) SendAttachments(chatId string, text string, attachments []DownloadedAttachment) (MessageRef, error) {
+	caption := ""
+	if len(attachments) > 0 && utf8.RuneCountInString(text) <= telegramCaptionMax {
+		caption, text = text, ""
+	}
+
+	ref, err := s.SendText(chatId, text)
+	if err != nil {
+		return ref, err
+	}
+
+	for i, a := range attachments {
+		if i > 0 {
+			caption = ""
+		}
+		id, err := s.upload(chatId, a, caption)
+		if err != nil {
+			return ref, err
+		}
+		if len(ref) == 0 && len(caption) > 0 {
+			ref = MessageRef(telegramCaptionRef + id)
+		} else if len(ref) == 0 {
+			ref = MessageRef(id)
+		}
+	}
+
+	return ref, nil
+}
+
+// Edit replaces the text of the message, or the caption of the attachment sent with the text
+func (s *TelegramSink) Edit(chatId string, ref MessageRef, text string) error {
+	if id, ok := strings.CutPrefix(string(ref), telegramCaptionRef); ok {
+		_, err := s.call("editMessageCaption", map[string]any{"chat_id": chatId, "message_id": id, "caption": text})
+		return err
+	}
+
+	_, err := s.call("editMessageText", map[string]any{"chat_id": chatId, "message_id": string(ref), "text": text})
+
+	return err
+}
+
+func (s *TelegramSink) Delete(chatId string, ref MessageRef) error {
+	id := strings.TrimPrefix(string(ref), telegramCaptionRef)
+	_, err := s.call("deleteMessage", map[string]any{"chat_id": chatId, "message_id": id})
+
+	return err
+}
+
+// React sets the bot's reaction, Telegram allows only the emoji of its reactions list
+func (s *TelegramSink) React(chatId string, ref MessageRef, emoji string) error {
+	id := strings.TrimPrefix(string(ref), telegramCaptionRef)
+	_, err := s.call("setMessageReaction", map[string]any{
+		"chat_id":    chatId,
+		"message_id": id,
+		"reaction":   []map[string]string{{"type": "emoji", "emoji": emoji}},
+	})
+
+	return err
+}
+
+func (s *TelegramSink) url(method string) string {
+	api := s.conf.APIURL
+	if len(api) == 0 {
+		api = defaultTelegramAPIURL
+	}
+
+	return strings.TrimRight(api, "/") + "/bot" + s.conf.BotToken + "/" + method
+}
+
+func (s *TelegramSink) call(method string, body any) (string, error) {
+	return s.request(method, nil, body)
+}
+
+// request calls the Bot API method, returning the id of the sent message (empty for the methods not sending any)
+func (s *TelegramSink) request(method string, header http.Header, body any) (string, error) {
+	var res telegramResponse
+	status, err := sinkRequest(s.client, "POST", s.url(method), header, body, &res)
+	if err != nil {
+		return "", fmt.Errorf("telegram %s: %w", method, err)
+	}
+	if !res.Ok {
+		return "", fmt.Errorf("telegram %s bad status %d: %s", method, status, res.Description)
+	}
+
+	var message struct {
+		MessageId int64 `json:"message_id"`
+	}
+	if json.Unmarshal(res.Result, &message) != nil || message.MessageId == 0 {
+		return "", nil
+	}
+
+	return strconv.FormatInt(message.MessageId, 10), nil
+}
+
+// upload posts the attachment with the method of its type, like sendPhoto for images
+func (s *TelegramSink) upload(chatId string, a DownloadedAttachment, caption string) (string, error) {
+	method, field := telegramUploadMethod(a.ContentType)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	_ = mw.WriteField("chat_id", chatId)
+	if len(caption) > 0 {
+		_ = mw.WriteField("caption", caption)
+	}
+
+	contentType := a.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {fmt.Sprintf(`form-data; name=%q; filename=%q`, field, attachmentName(a.SignalAttachments))},
+		"Content-Type":        {contentType},
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(a.Content); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+
+	return s.request(method, http.Header{"Content-Type": {mw.FormDataContentType()}}, &buf)
+}
+
+func telegramUploadMethod(contentType string) (string, string) {
+	switch {
+	case contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/webp":
+		return "sendPhoto", "photo"
+	case strings.HasPrefix(contentType, "video/"):
+		return "sendVideo", "video"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "sendAudio", "audio"
+	default:
+		return "sendDocument", "document"
+	}
+}
+
+// splitText cuts the text into chunks of at most max runes, preferring the line breaks
+func splitText(text string, max int) []string {
+	var chunks []string
+	for len(text) > 0 {
+		if utf8.RuneCountInString(text) <= max {
+			chunks = append(chunks, text)
+			break
+		}
+
+		cut, n := len(text), 0
+		for i := range text {
+			if n == max {
+				cut = i
+				break
+			}
+			n++
+		}
+		if nl := strings.LastIndex(text[:cut], "\n"); nl > 0 {
+			cut = nl + 1
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+
+	return chunks
+}
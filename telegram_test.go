@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTelegramSendText(t *testing.T) {
+	id := 100
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		id++
+		return 200, map[string]any{"ok": true, "result": map[string]any{"message_id": id}}
+	})
+	sink := NewTelegramSink(&TelegramConfig{BotToken: "123:abc", APIURL: srv.URL})
+
+	text := strings.Repeat("line of text\n", 500) //longer than a message
+	ref, err := sink.SendText("-100200", text)
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if ref != "101" {
+		t.Errorf("ref = %q, want the first message", ref)
+	}
+
+	calls := srv.recorded()
+	if len(calls) != 2 {
+		t.Fatalf("calls = %d, want the text split in two", len(calls))
+	}
+	var sent strings.Builder
+	for _, call := range calls {
+		if call.Path != "/bot123:abc/sendMessage" {
+			t.Errorf("path = %s", call.Path)
+		}
+		body := jsonBody(t, call)
+		chunk, _ := body["text"].(string)
+		if body["chat_id"] != "-100200" || utf8.RuneCountInString(chunk) > telegramTextMax || !strings.HasSuffix(chunk, "\n") {
+			t.Errorf("message = %v", body["chat_id"])
+		}
+		sent.WriteString(chunk)
+	}
+	if sent.String() != text {
+		t.Error("the chunks don't make the text")
+	}
+}
+
+func TestTelegramSendAttachmentsWithCaption(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 200, map[string]any{"ok": true, "result": map[string]any{"message_id": 7}}
+	})
+	sink := NewTelegramSink(&TelegramConfig{BotToken: "123:abc", APIURL: srv.URL})
+
+	attachments := []DownloadedAttachment{
+		{SignalAttachments: SignalAttachments{ContentType: "image/jpeg", Filename: "a.jpg"}, Content: []byte("jpeg")},
+		{SignalAttachments: SignalAttachments{ContentType: "application/pdf", Filename: "b.pdf"}, Content: []byte("pdf")},
+	}
+	ref, err := sink.SendAttachments("@channel", "caption text", attachments)
+	if err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if ref != telegramCaptionRef+"7" {
+		t.Errorf("ref = %q, want the caption reference", ref)
+	}
+
+	calls := srv.recorded()
+	if len(calls) != 2 || calls[0].Path != "/bot123:abc/sendPhoto" || calls[1].Path != "/bot123:abc/sendDocument" {
+		t.Fatalf("calls = %+v", calls)
+	}
+
+	fields := multipartFields(t, calls[0])
+	if fields["chat_id"] != "@channel" || fields["caption"] != "caption text" || fields["photo"] != "jpeg" {
+		t.Errorf("photo fields = %v", fields)
+	}
+	fields = multipartFields(t, calls[1])
+	if _, ok := fields["caption"]; ok || fields["document"] != "pdf" {
+		t.Errorf("document fields = %v", fields)
+	}
+}
+
+func multipartFields(t *testing.T, call sinkCall) map[string]string {
+	t.Helper()
+
+	_, params, err := mime.ParseMediaType(call.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("content type error: %v", err)
+	}
+
+	fields := make(map[string]string)
+	mr := multipart.NewReader(bytes.NewReader(call.Body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("multipart error: %v", err)
+		}
+		value, _ := io.ReadAll(part)
+		fields[part.FormName()] = string(value)
+	}
+
+	return fields
+}
+
+func TestTelegramEditDeleteReact(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 200, map[string]any{"ok": true, "result": true}
+	})
+	sink := NewTelegramSink(&TelegramConfig{BotToken: "123:abc", APIURL: srv.URL})
+
+	if err := sink.Edit("1", "5", "text"); err != nil {
+		t.Fatalf("edit error: %v", err)
+	}
+	if err := sink.Edit("1", MessageRef(telegramCaptionRef+"6"), "caption"); err != nil {
+		t.Fatalf("caption edit error: %v", err)
+	}
+	if err := sink.Delete("1", MessageRef(telegramCaptionRef+"6")); err != nil {
+		t.Fatalf("delete error: %v", err)
+	}
+	if err := sink.React("1", "5", "👍"); err != nil {
+		t.Fatalf("react error: %v", err)
+	}
+
+	calls := srv.recorded()
+	want := []struct {
+		path, field, value string
+	}{
+		{"/bot123:abc/editMessageText", "text", "text"},
+		{"/bot123:abc/editMessageCaption", "caption", "caption"},
+		{"/bot123:abc/deleteMessage", "message_id", "6"},
+		{"/bot123:abc/setMessageReaction", "message_id", "5"},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %d", len(calls))
+	}
+	for i, w := range want {
+		if calls[i].Path != w.path {
+			t.Errorf("call %d path = %s, want %s", i, calls[i].Path, w.path)
+		}
+		if body := jsonBody(t, calls[i]); body[w.field] != w.value {
+			t.Errorf("call %d %s = %v, want %s", i, w.field, body[w.field], w.value)
+		}
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	srv := newSinkServer(t, func(call sinkCall) (int, any) {
+		return 400, map[string]any{"ok": false, "error_code": 400, "description": "Bad Request: chat not found"}
+	})
+	sink := NewTelegramSink(&TelegramConfig{BotToken: "123:abc", APIURL: srv.URL})
+
+	_, err := sink.SendText("1", "hello")
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("error = %v", err)
+	}
+
+	srv.Close()
+	_, err = sink.SendText("1", "hello")
+	if err == nil || strings.Contains(err.Error(), "123:abc") {
+		t.Errorf("network error = %v, must not show the bot token", err)
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{name: "short", text: "abc", max: 5, want: []string{"abc"}},
+		{name: "at line breaks", text: "ab\ncd\nef", max: 6, want: []string{"ab\ncd\n", "ef"}},
+		{name: "no line breaks", text: "abcdefg", max: 3, want: []string{"abc", "def", "g"}},
+		{name: "runes", text: "ёжик", max: 2, want: []string{"ёж", "ик"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitText(tt.text, tt.max)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type (
+	TransformType string
+
+	// TransformConfig is a step of the forwarded text transformation, see TransformType constants for the parameters
+	TransformConfig struct {
+		Type        TransformType `json:"type"`
+		Prefixes    []string      `json:"prefixes,omitempty"`    //strip_prefix: the rule's starts_with by default
+		Pattern     string        `json:"pattern,omitempty"`     //replace: regular expression
+		Replacement string        `json:"replacement,omitempty"` //replace: may refer to the groups like $1
+		MaxLength   int           `json:"max_length,omitempty"`  //truncate: max characters including the ellipsis
+		Text        string        `json:"text,omitempty"`        //footer
+
+		compiled TextTransform //set by the config validation, so the pattern is compiled once
+	}
+
+	// TextTransform is a compiled transformation step
+	TextTransform func(text string) string
+)
+
+const (
+	TransformStripPrefix TransformType = "strip_prefix" //removes the first matching prefix
+	TransformReplace     TransformType = "replace"      //replaces the pattern matches
+	TransformTruncate    TransformType = "truncate"     //cuts the text to max_length with an ellipsis
+	TransformStripURLs   TransformType = "strip_urls"   //removes http(s) and www links
+	TransformFooter      TransformType = "footer"       //appends the text on a new line
+)
+
+var urlRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// Compile validates the step and returns its function; rule provides the defaults, like starts_with
+func (tc *TransformConfig) Compile(rule *ConfigGroup) (TextTransform, error) {
+	switch tc.Type {
+	case TransformStripPrefix:
+		prefixes := tc.Prefixes
+		if len(prefixes) == 0 {
+			prefixes = rule.StartsWith
+		}
+		if len(prefixes) == 0 {
+			return nil, fmt.Errorf("strip_prefix transform requires prefixes or starts_with")
+		}
+		return StripPrefix(prefixes), nil
+	case TransformReplace:
+		if len(tc.Pattern) == 0 {
+			return nil, fmt.Errorf("replace transform requires pattern")
+		}
+		re, err := regexp.Compile(tc.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("replace transform pattern: %w", err)
+		}
+		return Replace(re, tc.Replacement), nil
+	case TransformTruncate:
+		if tc.MaxLength < 2 {
+			return nil, fmt.Errorf("truncate transform requires max_length of at least 2")
+		}
+		return Truncate(tc.MaxLength), nil
+	case TransformStripURLs:
+		return StripURLs, nil
+	case TransformFooter:
+		if len(tc.Text) == 0 {
+			return nil, fmt.Errorf("footer transform requires text")
+		}
+		return Footer(tc.Text), nil
+	default:
+		return nil, fmt.Errorf("invalid transform type: %s", tc.Type)
+	}
+}
+
+// ApplyTransforms runs the rule's transforms over the text in their order; the steps not compiled
+// by the config validation are compiled here
+func ApplyTransforms(rule *ConfigGroup, text string) (string, error) {
+	for i := range rule.Transforms {
+		fn := rule.Transforms[i].compiled
+		if fn == nil {
+			var err error
+			if fn, err = rule.Transforms[i].Compile(rule); err != nil {
+				return text, err
+			}
+		}
+		text = fn(text)
+	}
+
+	return text, nil
+}
+
+func StripPrefix(prefixes []string) TextTransform {
+	return func(text string) string {
+		for _, p := range prefixes {
+			if trimmed, ok := strings.CutPrefix(text, p); ok {
+				return strings.TrimLeft(trimmed, " \t\n")
+			}
+		}
+
+		return text
+	}
+}
+
+func Replace(re *regexp.Regexp, replacement string) TextTransform {
+	return func(text string) string {
+		return re.ReplaceAllString(text, replacement)
+	}
+}
+
+func Truncate(maxLength int) TextTransform {
+	return func(text string) string {
+		runes := []rune(text)
+		if len(runes) <= maxLength {
+			return text
+		}
+
+		return strings.TrimRight(string(runes[:maxLength-1]), " \t\n") + "…"
+	}
+}
+
+func StripURLs(text string) string {
+	lines := strings.Split(urlRe.ReplaceAllString(text, ""), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func Footer(footer string) TextTransform {
+	return func(text string) string {
+		if len(text) == 0 {
+			return footer
+		}
+
+		return text + "\n" + footer
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyTransforms(t *testing.T) {
+	tests := []struct {
+		name       string
+		startsWith []string
+		transforms []TransformConfig
+		text       string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name: "no transforms",
+			text: "hello",
+			want: "hello",
+		},
+		{
+			name:       "strip prefix from starts_with",
+			startsWith: []string{"#news", "#info"},
+			transforms: []TransformConfig{{Type: TransformStripPrefix}},
+			text:       "#info  update\nline",
+			want:       "update\nline",
+		},
+		{
+			name:       "strip own prefixes, first match only",
+			transforms: []TransformConfig{{Type: TransformStripPrefix, Prefixes: []string{"a", "ab"}}},
+			text:       "abc",
+			want:       "bc",
+		},
+		{
+			name:       "strip prefix without match",
+			transforms: []TransformConfig{{Type: TransformStripPrefix, Prefixes: []string{"#x"}}},
+			text:       "text #x",
+			want:       "text #x",
+		},
+		{
+			name:       "replace with groups",
+			transforms: []TransformConfig{{Type: TransformReplace, Pattern: `(\w+)@(\w+)`, Replacement: "$1 at $2"}},
+			text:       "mail bob@host or amy@box",
+			want:       "mail bob at host or amy at box",
+		},
+		{
+			name:       "truncate keeps short text",
+			transforms: []TransformConfig{{Type: TransformTruncate, MaxLength: 5}},
+			text:       "short",
+			want:       "short",
+		},
+		{
+			name:       "truncate counts runes",
+			transforms: []TransformConfig{{Type: TransformTruncate, MaxLength: 4}},
+			text:       "привет",
+			want:       "при…",
+		},
+		{
+			name:       "truncate trims the space before the ellipsis",
+			transforms: []TransformConfig{{Type: TransformTruncate, MaxLength: 5}},
+			text:       "abc defgh",
+			want:       "abc…",
+		},
+		{
+			name:       "strip urls",
+			transforms: []TransformConfig{{Type: TransformStripURLs}},
+			text:       "see https://example.org/a?b=1 and www.example.com now\nhttp://x.y",
+			want:       "see and now",
+		},
+		{
+			name:       "footer",
+			transforms: []TransformConfig{{Type: TransformFooter, Text: "-- bot"}},
+			text:       "text",
+			want:       "text\n-- bot",
+		},
+		{
+			name:       "footer of empty text",
+			transforms: []TransformConfig{{Type: TransformFooter, Text: "-- bot"}},
+			text:       "",
+			want:       "-- bot",
+		},
+		{
+			name: "steps run in order",
+			transforms: []TransformConfig{
+				{Type: TransformStripPrefix, Prefixes: []string{"!"}},
+				{Type: TransformStripURLs},
+				{Type: TransformTruncate, MaxLength: 8},
+				{Type: TransformFooter, Text: "end"},
+			},
+			text: "! long message https://example.org",
+			want: "long me…\nend",
+		},
+		{
+			name:       "invalid pattern",
+			transforms: []TransformConfig{{Type: TransformReplace, Pattern: "("}},
+			text:       "text",
+			want:       "text",
+			wantErr:    true,
+		},
+		{
+			name:       "unknown type",
+			transforms: []TransformConfig{{Type: "upper"}},
+			text:       "text",
+			want:       "text",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &ConfigGroup{StartsWith: tt.startsWith, Transforms: tt.transforms}
+			got, err := ApplyTransforms(rule, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCompilesTransforms(t *testing.T) {
+	conf := &Config{CLIAddress: "localhost:8080", SelfNumber: "+10000000000", Forwarding: []ConfigGroup{{
+		GroupId:           "source",
+		IsEnabled:         true,
+		ReceiversGroupIds: []string{"receiver"},
+		Transforms:        []TransformConfig{{Type: TransformReplace, Pattern: `\d+`, Replacement: "#"}},
+	}}}
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("validate error: %v", err)
+	}
+
+	rule := &conf.Forwarding[0]
+	if rule.Transforms[0].compiled == nil {
+		t.Fatal("transform is not compiled by the validation")
+	}
+
+	rule.Transforms[0].Pattern = "(" //the compiled step is used, the pattern is not compiled again
+	got, err := ApplyTransforms(rule, "call 112 or 911")
+	if err != nil {
+		t.Fatalf("apply error: %v", err)
+	}
+	if want := "call # or #"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := (&TransformConfig{Type: TransformReplace, Pattern: "("}).Compile(rule); err == nil || !strings.Contains(err.Error(), "pattern") {
+		t.Errorf("invalid pattern error = %v", err)
+	}
+}
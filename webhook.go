@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookAttachmentsURL    = "url"    //link to the prepared attachment on the bot's api, see WebhookFiles
+	WebhookAttachmentsBase64 = "base64" //the attachment content in the payload
+	WebhookAttachmentsNone   = "none"   //only the attachment metadata (default)
+
+	defaultWebhookTimeout  = 10 * time.Second
+	defaultWebhookURLTTL   = time.Hour
+	maxWebhookURLTTLSec    = 24 * 60 * 60
+	webhookSignatureHeader = "X-Signature-256"
+	webhookFilesPath       = "/webhooks/files/"
+)
+
+// webhookBackoff is the delay before the first retry, doubled for every next one
+var webhookBackoff = time.Second
+
+type (
+	// WebhookConfig is a receiver getting the forwarded messages as JSON POST requests
+	WebhookConfig struct {
+		URL         string            `json:"url"`
+		Secret      string            `json:"secret,omitempty"`      //signs the body with HMAC-SHA256, see webhookSignatureHeader
+		TimeoutSec  int               `json:"timeout_sec,omitempty"` //per attempt, 10 by default
+		Retries     int               `json:"retries,omitempty"`     //more attempts on network errors, 429 and 5xx responses
+		Attachments string            `json:"attachments,omitempty"` //url, base64 or none
+		URLTTLSec   int               `json:"url_ttl_sec,omitempty"` //how long the attachment links work, an hour by default
+		Headers     map[string]string `json:"headers,omitempty"`     //added to the requests, like Authorization
+	}
+
+	WebhookPayload struct {
+		GroupId     string              `json:"group_id"`
+		GroupName   string              `json:"group_name,omitempty"`
+		Sender      string              `json:"sender,omitempty"` //as the rule's author_display allows
+		SenderUuid  string              `json:"sender_uuid,omitempty"`
+		Timestamp   uint64              `json:"timestamp,omitempty"` //Signal timestamp of the received message
+		Message     string              `json:"message,omitempty"`
+		Attachments []WebhookAttachment `json:"attachments,omitempty"`
+	}
+
+	WebhookAttachment struct {
+		Id          string `json:"id"`
+		ContentType string `json:"content_type,omitempty"`
+		Filename    string `json:"filename,omitempty"`
+		Size        uint64 `json:"size,omitempty"`
+		Caption     string `json:"caption,omitempty"`
+		URL         string `json:"url,omitempty"`  //fetched with the webhook's secret as the bearer token
+		Data        string `json:"data,omitempty"` //base64
+	}
+
+	// WebhookFiles keeps the prepared attachments linked from the webhook payloads until the links expire;
+	// a file is served only to the requests with its webhook's secret
+	WebhookFiles struct {
+		mu    sync.Mutex
+		files map[string]webhookFile
+	}
+
+	webhookFile struct {
+		attachment DownloadedAttachment
+		secret     string
+		expiresAt  time.Time
+	}
+)
+
+func (wc *WebhookConfig) Validate() error {
+	u, err := url.Parse(wc.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("webhook url must be an http(s) url: %s", wc.URL)
+	}
+	if wc.TimeoutSec < 0 || wc.Retries < 0 {
+		return fmt.Errorf("webhook timeout and retries must not be negative")
+	}
+	if wc.URLTTLSec < 0 || wc.URLTTLSec > maxWebhookURLTTLSec {
+		return fmt.Errorf("webhook url ttl must be from 0 to %d seconds", maxWebhookURLTTLSec)
+	}
+
+	switch wc.Attachments {
+	case WebhookAttachmentsURL:
+		if len(wc.Secret) == 0 {
+			return fmt.Errorf("webhook attachments mode url requires the secret, the links are fetched with it")
+		}
+		return nil
+	case "", WebhookAttachmentsBase64, WebhookAttachmentsNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid webhook attachments mode: %s", wc.Attachments)
+	}
+}
+
+func (wc *WebhookConfig) Timeout() time.Duration {
+	if wc.TimeoutSec > 0 {
+		return time.Duration(wc.TimeoutSec) * time.Second
+	}
+
+	return defaultWebhookTimeout
+}
+
+func (wc *WebhookConfig) URLTTL() time.Duration {
+	if wc.URLTTLSec > 0 {
+		return time.Duration(wc.URLTTLSec) * time.Second
+	}
+
+	return defaultWebhookURLTTL
+}
+
+// SignWebhook returns the signature header value for the body
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendWebhook posts the body, retrying with a growing delay
+func SendWebhook(wc *WebhookConfig, body []byte) error {
+	client := &http.Client{Timeout: wc.Timeout()}
+
+	var err error
+	for attempt := 0; attempt <= wc.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(webhookBackoff << (attempt - 1))
+		}
+
+		var retry bool
+		if retry, err = postWebhook(client, wc, body); err == nil || !retry {
+			return err
+		}
+		Rlog.Debugf("webhook attempt %d failed: %v", attempt+1, err)
+	}
+
+	return err
+}
+
+// postWebhook makes one attempt, telling whether it is worth retrying on error
+func postWebhook(client *http.Client, wc *WebhookConfig, body []byte) (bool, error) {
+	r, err := http.NewRequest("POST", wc.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range wc.Headers {
+		r.Header.Set(k, v)
+	}
+	if len(wc.Secret) > 0 {
+		r.Header.Set(webhookSignatureHeader, SignWebhook(wc.Secret, body))
+	}
+
+	res, err := client.Do(r)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+		return retry, fmt.Errorf("webhook bad status: %s", res.Status)
+	}
+
+	return false, nil
+}
+
+// webhookPayload builds the payload for the forwarded message; envs are the received messages, if known
+func (p *Processor) webhookPayload(rule *ConfigGroup, decision RoutingDecision, envs []*SignalEnvelope) WebhookPayload {
+	payload := WebhookPayload{
+		GroupId:   rule.SourceGroupId(),
+		GroupName: p.directory.GroupName(rule.SourceGroupId()),
+		Message:   decision.Text,
+	}
+
+	if len(envs) > 0 && rule.ForwardingMode != FwModeDigest { //digest messages are of many authors
+		payload.Sender = p.AuthorName(rule, envs[0])
+		payload.SenderUuid = AuthorUuid(rule, envs[0])
+		payload.Timestamp = envs[0].Timestamp
+	}
+
+	return payload
+}
+
+// dispatchWebhooks posts the forwarded message to the rule's webhooks in the background, with the attachments as prepared for the other receivers;
+// it tells whether the rule has any
+func (p *Processor) dispatchWebhooks(conf *Config, decision RoutingDecision, envs []*SignalEnvelope, downloaded []DownloadedAttachment) bool {
+	rule := decision.Rule
+	if len(rule.Webhooks) == 0 {
+		return false
+	}
+
+	payload := p.webhookPayload(rule, decision, envs)
+	groupId := payload.GroupId
+
+	go func() {
+		for i := range rule.Webhooks {
+			wc := &rule.Webhooks[i]
+
+			link := func(a *DownloadedAttachment) string {
+				id := p.webhookFiles.Add(*a, wc.Secret, wc.URLTTL(), time.Now())
+				return strings.TrimRight(conf.Api.PublicURL, "/") + webhookFilesPath + id
+			}
+			payload.Attachments = webhookAttachments(wc.Attachments, downloaded, link)
+			body, err := json.Marshal(payload)
+			if err != nil {
+				Rlog.Error("json marshal err: ", err)
+				return
+			}
+
+			go func() {
+				Rlog.Infof("SENDING MESSAGE TO WEBHOOK %s", wc.URL)
+				if err := SendWebhook(wc, body); err != nil {
+					Rlog.Errorf("webhook %s error: %v", wc.URL, err)
+					p.activity.Error(groupId, fmt.Errorf("webhook %s: %w", wc.URL, err))
+				}
+			}()
+		}
+	}()
+
+	return true
+}
+
+// webhookAttachments describes the attachments in the mode, link returns the url of the attachment in url mode
+func webhookAttachments(mode string, downloaded []DownloadedAttachment, link func(a *DownloadedAttachment) string) []WebhookAttachment {
+	result := make([]WebhookAttachment, 0, len(downloaded))
+	for i, a := range downloaded {
+		wa := WebhookAttachment{Id: a.Id, ContentType: a.ContentType, Filename: a.Filename, Size: a.Size, Caption: a.Caption}
+		switch mode {
+		case WebhookAttachmentsURL:
+			wa.URL = link(&downloaded[i])
+		case WebhookAttachmentsBase64:
+			wa.Data = base64.StdEncoding.EncodeToString(a.Content)
+		}
+		result = append(result, wa)
+	}
+
+	return result
+}
+
+func NewWebhookFiles() *WebhookFiles {
+	return &WebhookFiles{files: make(map[string]webhookFile)}
+}
+
+// Add keeps the attachment for the ttl and returns its random id; the expired files are dropped
+func (f *WebhookFiles) Add(a DownloadedAttachment, secret string, ttl time.Duration, now time.Time) string {
+	token := make([]byte, 32)
+	_, _ = rand.Read(token)
+	id := hex.EncodeToString(token)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, file := range f.files {
+		if !now.Before(file.expiresAt) {
+			delete(f.files, key)
+		}
+	}
+	f.files[id] = webhookFile{attachment: a, secret: secret, expiresAt: now.Add(ttl)}
+
+	return id
+}
+
+// Get returns the file unless it is unknown or expired
+func (f *WebhookFiles) Get(id string, now time.Time) (webhookFile, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, ok := f.files[id]
+	if !ok || !now.Before(file.expiresAt) {
+		return webhookFile{}, false
+	}
+
+	return file, true
+}
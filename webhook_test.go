@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendWebhookSignature(t *testing.T) {
+	body := []byte(`{"group_id":"g","message":"hello"}`)
+
+	var got http.Header
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		gotBody, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	wc := &WebhookConfig{URL: srv.URL, Secret: "s3cret", Headers: map[string]string{"Authorization": "Bearer token"}}
+	if err := SendWebhook(wc, body); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	if string(gotBody) != string(body) {
+		t.Errorf("body = %s", gotBody)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.Get(webhookSignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", got.Get(webhookSignatureHeader), want)
+	}
+	if got.Get("Authorization") != "Bearer token" || got.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", got)
+	}
+}
+
+func TestSendWebhookWithoutSecret(t *testing.T) {
+	var signature atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature.Store(r.Header.Get(webhookSignatureHeader))
+	}))
+	defer srv.Close()
+
+	if err := SendWebhook(&WebhookConfig{URL: srv.URL}, []byte("{}")); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if s := signature.Load(); s != "" {
+		t.Errorf("unexpected signature %q", s)
+	}
+}
+
+func TestSendWebhookRetries(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	tests := []struct {
+		name      string
+		statuses  []int //responses of the attempts, the last one repeats
+		retries   int
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "success", statuses: []int{200}, retries: 3, wantCalls: 1},
+		{name: "5xx then success", statuses: []int{503, 500, 204}, retries: 3, wantCalls: 3},
+		{name: "429 is retried", statuses: []int{429, 200}, retries: 1, wantCalls: 2},
+		{name: "retries exhausted", statuses: []int{502}, retries: 2, wantCalls: 3, wantErr: true},
+		{name: "4xx is not retried", statuses: []int{400}, retries: 3, wantCalls: 1, wantErr: true},
+		{name: "no retries", statuses: []int{500}, retries: 0, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1)) - 1
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses)-1)])
+			}))
+			defer srv.Close()
+
+			err := SendWebhook(&WebhookConfig{URL: srv.URL, Retries: tt.retries}, []byte("{}"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestSendWebhookNetworkErrorIsRetried(t *testing.T) {
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	addr := srv.URL
+	srv.Close() //connections are refused
+
+	err := SendWebhook(&WebhookConfig{URL: addr, Retries: 1}, []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "connect") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestWebhookAttachments(t *testing.T) {
+	downloaded := []DownloadedAttachment{{
+		SignalAttachments: SignalAttachments{Id: "a1", ContentType: "image/jpeg", Filename: "photo.jpg", Size: 3},
+		Content:           []byte("abc"),
+	}}
+	link := func(a *DownloadedAttachment) string { return "https://bot.example.org/webhooks/files/" + a.Id }
+
+	if got := webhookAttachments(WebhookAttachmentsNone, downloaded, link); len(got) != 1 || got[0].Data != "" || got[0].URL != "" || got[0].Filename != "photo.jpg" {
+		t.Errorf("none mode = %+v", got)
+	}
+	if got := webhookAttachments(WebhookAttachmentsBase64, downloaded, link); len(got) != 1 || got[0].Data != "YWJj" || got[0].URL != "" {
+		t.Errorf("base64 mode = %+v", got)
+	}
+	if got := webhookAttachments(WebhookAttachmentsURL, downloaded, link); len(got) != 1 || got[0].URL != "https://bot.example.org/webhooks/files/a1" || got[0].Data != "" {
+		t.Errorf("url mode = %+v", got)
+	}
+}
+
+func TestWebhookFileHandler(t *testing.T) {
+	p := &Processor{webhookFiles: NewWebhookFiles()}
+	api := newAPI(p)
+	api.configureWebhookRoutes(api.r)
+
+	now := time.Now()
+	a := DownloadedAttachment{SignalAttachments: SignalAttachments{ContentType: "image/jpeg", Filename: "photo.jpg"}, Content: []byte("stripped")}
+	id := p.webhookFiles.Add(a, "s3cret", time.Minute, now)
+	expired := p.webhookFiles.Add(a, "s3cret", time.Minute, now.Add(-2*time.Minute))
+
+	tests := []struct {
+		name   string
+		id     string
+		token  string
+		status int
+	}{
+		{name: "served", id: id, token: "s3cret", status: http.StatusOK},
+		{name: "no secret", id: id, status: http.StatusUnauthorized},
+		{name: "wrong secret", id: id, token: "other", status: http.StatusUnauthorized},
+		{name: "expired", id: expired, token: "s3cret", status: http.StatusNotFound},
+		{name: "unknown", id: "0123", token: "s3cret", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", webhookFilesPath+tt.id, nil)
+			if len(tt.token) > 0 {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			api.r.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusOK && (w.Body.String() != "stripped" || w.Header().Get("Content-Type") != "image/jpeg" || !strings.Contains(w.Header().Get("Content-Disposition"), "photo.jpg")) {
+				t.Errorf("response = %v %q", w.Header(), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		wc      WebhookConfig
+		wantErr bool
+	}{
+		{name: "valid", wc: WebhookConfig{URL: "https://example.org/hook"}},
+		{name: "base64", wc: WebhookConfig{URL: "http://localhost:9000", Attachments: WebhookAttachmentsBase64}},
+		{name: "not http", wc: WebhookConfig{URL: "ftp://example.org"}, wantErr: true},
+		{name: "negative retries", wc: WebhookConfig{URL: "https://example.org", Retries: -1}, wantErr: true},
+		{name: "url", wc: WebhookConfig{URL: "https://example.org", Attachments: WebhookAttachmentsURL, Secret: "s"}},
+		{name: "url without secret", wc: WebhookConfig{URL: "https://example.org", Attachments: WebhookAttachmentsURL}, wantErr: true},
+		{name: "url ttl too long", wc: WebhookConfig{URL: "https://example.org", URLTTLSec: 2 * 24 * 60 * 60}, wantErr: true},
+		{name: "unknown mode", wc: WebhookConfig{URL: "https://example.org", Attachments: "file"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.wc.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}